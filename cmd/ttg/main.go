@@ -0,0 +1,351 @@
+// Command ttg pass users permissions from twitch to telegram chat
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/leporel/ttg/core"
+	"github.com/leporel/ttg/patreon"
+	"github.com/leporel/ttg/storage"
+	"github.com/leporel/ttg/telegram"
+	"github.com/leporel/ttg/twitch"
+)
+
+type config struct {
+	// Provider identity provider: twitch or patreon
+	Provider string
+
+	TwitchAppID       string
+	TwitchSecCode     string
+	TwitchChannelName string
+	// Helix and oauth2 base urls, empty for twitch
+	TwitchAPIURL  string
+	TwitchAuthURL string
+	// TwitchFounders twitch ids of channel founders, helix does not report founder badge
+	TwitchFounders []string
+
+	// PatreonCampaign campaign id, oauth app id and secret are same as TwitchAppID and TwitchSecCode
+	PatreonCampaign string
+	// PatreonMinCents minimal pledge, 0 for any active patron
+	PatreonMinCents int
+	// Patreon site url, empty for patreon
+	PatreonURL string
+
+	TelegramBotToken string
+	TelegramGroup    int
+	TelegramOwner    int
+	// Bot api url, empty for telegram
+	TelegramAPIURL string
+
+	Host     string
+	Database string
+
+	core.Config
+}
+
+func main() {
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	db, err := storage.New(cfg.Database)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	app, err := newProvider(cfg, db)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	tg, err := telegram.NewBot(cfg.TelegramBotToken, cfg.TelegramAPIURL, cfg.TelegramGroup, cfg.TelegramOwner, cfg.Host)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	tg.SetMessages(cfg.Messages)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	core.New(cfg.Config, app, tg, db).Run(ctx)
+}
+
+// newProvider create identity provider from config, with creator token loaded from db
+func newProvider(cfg *config, db *storage.Storage) (core.IdentityProvider, error) {
+	token, err := db.GetToken(storage.TokenBroadcaster)
+	switch {
+	case err == sql.ErrNoRows:
+		log.Println("Broadcaster not connected, send /connect to bot")
+		token = nil
+	case err != nil:
+		return nil, err
+	}
+
+	switch cfg.Provider {
+	case "patreon":
+		app := patreon.NewClient(cfg.PatreonCampaign, cfg.TwitchAppID, cfg.TwitchSecCode, cfg.Host, cfg.PatreonURL, cfg.PatreonMinCents)
+		app.SetTokenSaver(db.SaveToken)
+		if token != nil {
+			app.SetCreatorToken(token)
+		}
+		return app, nil
+	default:
+		app, err := twitch.NewClient(cfg.TwitchChannelName, cfg.TwitchAppID, cfg.TwitchSecCode, cfg.Host, cfg.TwitchAPIURL, cfg.TwitchAuthURL)
+		if err != nil {
+			return nil, err
+		}
+		app.SetTokenSaver(db.SaveToken)
+		if token != nil {
+			app.SetModeratorToken(token)
+		}
+		app.SetFounders(cfg.TwitchFounders)
+		return app, nil
+	}
+}
+
+func loadConfig() (*config, error) {
+	var cfg config
+	var err error
+
+	flag.StringVar(&cfg.Host, "host", "", "Host where you run this bot (IP or URL)")
+
+	flag.StringVar(&cfg.Provider, "provider", "twitch", "Identity provider: twitch or patreon")
+
+	flag.StringVar(&cfg.TwitchAppID, "app", "", "Twitch (or Patreon) app id")
+	flag.StringVar(&cfg.TwitchSecCode, "code", "", "Twitch (or Patreon) app secret code")
+	flag.StringVar(&cfg.TwitchChannelName, "channel", "", "Your channel name")
+	flag.StringVar(&cfg.TwitchAPIURL, "twitch-api", "", "Twitch helix api url, for testing")
+	flag.StringVar(&cfg.TwitchAuthURL, "twitch-auth", "", "Twitch oauth2 url, for testing")
+
+	flag.StringVar(&cfg.PatreonCampaign, "campaign", "", "Patreon campaign id")
+	flag.IntVar(&cfg.PatreonMinCents, "patreon-min", 0, "Patreon minimal pledge in cents, 0 for any active patron")
+	flag.StringVar(&cfg.PatreonURL, "patreon-url", "", "Patreon url, for testing")
+
+	flag.IntVar(&cfg.TelegramGroup, "group", 0, "Your telegram group(chat) id")
+	flag.IntVar(&cfg.TelegramOwner, "owner", 0, "Your telegram user id")
+	flag.StringVar(&cfg.TelegramBotToken, "token", "", "Telegram bot token")
+	flag.StringVar(&cfg.TelegramAPIURL, "telegram-api", "", "Telegram bot api url, for testing")
+
+	flag.StringVar(&cfg.Database, "db", "db.sqlite", "Database file")
+
+	flag.BoolVar(&cfg.Init, "init", false, "First init: members seen by bot before start are grandfathered to whitelist once, send /init to add admins and imported members")
+
+	flag.IntVar(&cfg.GraceSweeps, "grace-sweeps", 3, "Failed sweeps before user revoked, 1 to revoke immediately")
+	flag.DurationVar(&cfg.GracePeriod, "grace", 0, "Time since first failed sweep before user revoked, 0 to disable")
+
+	flag.DurationVar(&cfg.LeaverExpiry, "leaver-expiry", 0, "Unlink users who left group after this time, 0 to keep them linked")
+	flag.DurationVar(&cfg.RelinkCooldown, "relink-cooldown", 24*time.Hour, "Forbid link of accounts after /unlink or /relink for this time, 0 to disable")
+
+	flag.Float64Var(&cfg.BreakerThreshold, "breaker", 0.2, "Abort sweep if fraction of users to revoke is above, 0 to disable")
+	flag.IntVar(&cfg.BreakerMin, "breaker-min", 3, "Minimum users to revoke before breaker can abort sweep")
+
+	var restricted, entitled, statusProfiles string
+	flag.StringVar(&restricted, "profile-restricted", "read_only", "Profile of not eligible users: read_only, text_only, no_media, full or permissions like messages+polls")
+	flag.StringVar(&entitled, "profile-entitled", "full", "Profile of eligible users (followers, patrons)")
+	flag.StringVar(&statusProfiles, "profile-status", "", "Profiles of eligible users by twitch status, by priority, e.g. \"sub1=full,vip=no_media\"")
+
+	var founders string
+	flag.StringVar(&founders, "founders", "", "Twitch user IDs of channel founders, comma separated, for founder role")
+	var roles string
+	flag.StringVar(&roles, "roles", "", "Telegram admin titles by twitch status, by priority, e.g. \"moderator=Mod:delete_messages+pin_messages,vip=VIP,sub3=Tier 3\"")
+
+	var language, messages string
+	flag.StringVar(&language, "language", "en", "Language of users with unknown or not supported telegram language: en or ru")
+	flag.StringVar(&messages, "messages", "", "Json file with own texts by language, e.g. {\"ru\": {\"rights_full\": \"Добро пожаловать!\"}}")
+
+	var page string
+	flag.StringVar(&page, "page", "", "Html template of callback pages to replace built-in, see core/pages/page.html")
+
+	var notify string
+	flag.StringVar(&notify, "notify", "digest", "Owner notifications: off, event (send each event) or digest")
+	flag.DurationVar(&cfg.DigestInterval, "digest", core.DefaultDigestInterval, "Owner notifications digest interval")
+
+	flag.Parse()
+
+	cfg.Addr = ":8444"
+
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("missing host")
+	}
+	if cfg.TwitchAppID == "" {
+		return nil, fmt.Errorf("missing app")
+	}
+	if cfg.TwitchSecCode == "" {
+		return nil, fmt.Errorf("missing code")
+	}
+	switch cfg.Provider {
+	case "twitch":
+		if cfg.TwitchChannelName == "" {
+			return nil, fmt.Errorf("missing channel")
+		}
+	case "patreon":
+		if cfg.PatreonCampaign == "" {
+			return nil, fmt.Errorf("missing campaign")
+		}
+	default:
+		return nil, fmt.Errorf("unknown provider %q", cfg.Provider)
+	}
+	if cfg.TelegramGroup == 0 {
+		return nil, fmt.Errorf("missing group")
+	}
+	if cfg.TelegramBotToken == "" {
+		return nil, fmt.Errorf("missing token")
+	}
+
+	if cfg.BreakerThreshold < 0 || cfg.BreakerThreshold > 1 {
+		return nil, fmt.Errorf("breaker must be between 0 and 1")
+	}
+	if cfg.GraceSweeps < 0 || cfg.GracePeriod < 0 {
+		return nil, fmt.Errorf("grace must not be negative")
+	}
+	if cfg.LeaverExpiry < 0 {
+		return nil, fmt.Errorf("leaver expiry must not be negative")
+	}
+	if cfg.RelinkCooldown < 0 {
+		return nil, fmt.Errorf("relink cooldown must not be negative")
+	}
+
+	cfg.Profiles.Restricted, err = core.ParseProfile(restricted)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Profiles.Entitled, err = core.ParseProfile(entitled)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Profiles.Status, err = core.ParseStatusProfiles(statusProfiles)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Roles, err = core.ParseRoles(roles)
+	if err != nil {
+		return nil, err
+	}
+	cfg.TwitchFounders, err = parseFounders(founders)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.TwitchFounders) > 0 && cfg.Provider != "twitch" {
+		return nil, fmt.Errorf("founders are only for twitch provider")
+	}
+	if err = telegram.ValidateRoles(cfg.Roles); err != nil {
+		return nil, err
+	}
+
+	cfg.Messages, err = loadMessages(messages, language)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Pages, err = loadPages(page)
+	if err != nil {
+		return nil, err
+	}
+
+	notifySet := false
+	flag.Visit(func(f *flag.Flag) {
+		notifySet = notifySet || f.Name == "notify"
+	})
+	cfg.Notify, err = notifyMode(notify, notifySet, cfg.TelegramOwner)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DigestInterval <= 0 {
+		return nil, fmt.Errorf("digest interval must be positive")
+	}
+
+	//if !CheckNumericOnly(cfg.TwitchChannelID) {
+	//	return nil, fmt.Errorf("TwitchChannelID key must be only numeric: '%s'", cfg.TwitchChannelID)
+	//}
+
+	return &cfg, nil
+}
+
+// parseFounders parse comma separated twitch user ids of -founders
+func parseFounders(s string) ([]string, error) {
+	var rs []string
+	for _, id := range strings.Split(s, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if !telegram.CheckNumericOnly(id) {
+			return nil, fmt.Errorf("founder must be twitch user ID: %q", id)
+		}
+		rs = append(rs, id)
+	}
+
+	return rs, nil
+}
+
+// notifyMode parse -notify, default mode turned off for setups without owner,
+// explicit mode require owner
+func notifyMode(notify string, explicit bool, owner int) (core.NotifyMode, error) {
+	mode, err := core.ParseNotifyMode(notify)
+	if err != nil {
+		return mode, err
+	}
+	if mode == core.NotifyOff || owner != 0 {
+		return mode, nil
+	}
+	if explicit {
+		return mode, fmt.Errorf("missing owner, required for notifications")
+	}
+
+	log.Println("Owner not set, notifications disabled")
+	return core.NotifyOff, nil
+}
+
+// loadMessages built-in texts overridden by json file, if set
+func loadMessages(path, language string) (*core.Messages, error) {
+	messages := core.NewMessages()
+
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		if err := messages.Override(f); err != nil {
+			return nil, fmt.Errorf("messages %s: %w", path, err)
+		}
+	}
+
+	if err := messages.SetDefault(language); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// loadPages built-in callback page template replaced by html file, if set
+func loadPages(path string) (*core.Pages, error) {
+	pages := core.NewPages()
+	if path == "" {
+		return pages, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := pages.Override(f); err != nil {
+		return nil, fmt.Errorf("page %s: %w", path, err)
+	}
+
+	return pages, nil
+}
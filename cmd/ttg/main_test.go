@@ -0,0 +1,779 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leporel/ttg/core"
+	"github.com/leporel/ttg/internal/fake"
+	"github.com/leporel/ttg/patreon"
+	"github.com/leporel/ttg/storage"
+	"github.com/leporel/ttg/telegram"
+	"github.com/leporel/ttg/twitch"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// newTwitch client of real twitch, skip test without credentials
+func newTwitch(t *testing.T) *twitch.Client {
+	if os.Getenv("TwitchAppID") == "" || os.Getenv("TwitchSecCode") == "" {
+		t.Skip("TwitchAppID and TwitchSecCode env required")
+	}
+
+	app, err := twitch.NewClient("leporel", os.Getenv("TwitchAppID"), os.Getenv("TwitchSecCode"), "localhost", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return app
+}
+
+func TestTTG_fetchFollowers(t *testing.T) {
+	app := newTwitch(t)
+
+	fwls, err := app.GetFollowers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var i = 0
+	for id, name := range fwls {
+		if i > 100 {
+			break
+		}
+
+		t.Log(id, name)
+
+		i++
+	}
+
+}
+
+func TestTTG_fetchTwitchUserInfo(t *testing.T) {
+	app := newTwitch(t)
+	srv := &http.Server{Addr: ":8444"}
+
+	link, err := app.GetAuthLink("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Println(link)
+
+	hnd := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		state := r.FormValue("state")
+
+		if state != "test" {
+			t.Fatal("wrong state")
+		}
+
+		accessToken, err := app.GetUserToken(r.FormValue("code"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		user, err := app.GetUser(accessToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(user)
+
+		follows, err := app.UserFollows(accessToken, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(follows)
+
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write([]byte(`<html><body>Authorization successful, bot will soon give to you rights</body></html>`)); err != nil {
+			log.Println("ERROR: ", err)
+		}
+
+		go func() {
+			time.Sleep(1 * time.Second)
+
+			srv.Shutdown(context.Background())
+		}()
+
+		return
+	})
+
+	log.Printf("Started running on http://localhost:8444 \n")
+	http.Handle("/auth/callback", hnd)
+
+	log.Println(srv.ListenAndServe())
+}
+
+// newOfflineTwitch client of fake helix server with broadcaster token of given scopes
+func newOfflineTwitch(t *testing.T, fh *fake.Helix, scopes ...string) *twitch.Client {
+	srv := httptest.NewServer(fh.Handler())
+	t.Cleanup(srv.Close)
+
+	app, err := twitch.NewClient(fh.Channel, "client", "secret", "localhost", srv.URL, srv.URL+"/oauth2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetModeratorToken(&storage.Token{
+		Name:         storage.TokenBroadcaster,
+		UserID:       fh.BroadcasterID,
+		AccessToken:  fh.Moderator,
+		RefreshToken: "mod-refresh",
+		Scopes:       scopes,
+		ExpiresAt:    time.Now().Add(time.Hour),
+	})
+
+	return app
+}
+
+// newOfflineService service wired to fake provider and telegram servers, no credentials and network required
+func newOfflineService(t *testing.T, cfg core.Config, app core.IdentityProvider, ft *fake.Telegram) (*core.Service, *storage.Storage) {
+	tgSrv := httptest.NewServer(ft.Handler())
+	t.Cleanup(tgSrv.Close)
+
+	db, err := storage.New(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tg, err := telegram.NewBot(ft.Token, tgSrv.URL, int(ft.Group), 1000, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := core.New(cfg, app, tg, db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		svc.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return svc, db
+}
+
+// linkOffline user ask link in private chat and provider redirect him back with code
+func linkOffline(t *testing.T, svc *core.Service, ft *fake.Telegram, tgID int, code string) {
+	authOffline(t, svc, ft, tgID, "/getlink", code)
+}
+
+// authOffline request oauth link with command and authorize with code
+func authOffline(t *testing.T, svc *core.Service, ft *fake.Telegram, tgID int, command, code string) {
+	if ft.Member(tgID).Role == tb.Left {
+		ft.Join(tgID)
+	}
+	ft.Push(fake.PrivateMessage(tgID, command))
+
+	// skip messages left from previous links
+	var match []string
+	for match == nil {
+		call, err := ft.Wait("sendMessage", 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		match = regexp.MustCompile(`href="([^"]+)"`).FindStringSubmatch(call.Param("text"))
+	}
+	link, err := url.Parse(html.UnescapeString(match[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := link.Query().Get("state")
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/auth/callback?state="+state+"&code="+code, nil)
+	handler, err := svc.CallbackHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback status %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// checkRevoked sweep must restrict and unlink user
+func checkRevoked(t *testing.T, svc *core.Service, db *storage.Storage, ft *fake.Telegram, tgID int) {
+	if err := svc.CheckPermissions(false); err != nil {
+		t.Fatal(err)
+	}
+	if ft.Member(tgID).CanSendMessages {
+		t.Fatal("user must be restricted")
+	}
+	if _, err := db.GetUserByTgId(tgID); err == nil {
+		t.Fatal("user must be unlinked")
+	}
+}
+
+func TestOfflineFlow(t *testing.T) {
+	fh := &fake.Helix{
+		BroadcasterID: "100",
+		Channel:       "leporel",
+		Moderator:     "mod-token",
+		Followers:     fake.NewFollowers(10),
+		Users:         map[string]string{},
+		Codes:         map[string]string{"code-5": "5"},
+	}
+	app := newOfflineTwitch(t, fh)
+
+	ft := fake.NewTelegram(-100500)
+	svc, db := newOfflineService(t, core.Config{GraceSweeps: 1}, app, ft)
+
+	const tgID = 42
+	linkOffline(t, svc, ft, tgID, "code-5")
+
+	user, err := db.GetUserByTgId(tgID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.TwitchID != 5 {
+		t.Fatalf("linked twitch id %d, want 5", user.TwitchID)
+	}
+	if !ft.Member(tgID).CanSendMessages {
+		t.Fatal("follower must be allowed to send messages")
+	}
+
+	// user unfollowed channel, sweep revoke rights
+	fh.Unfollow("5")
+	checkRevoked(t, svc, db, ft, tgID)
+}
+
+func TestOfflinePatreonFlow(t *testing.T) {
+	fp := &fake.Patreon{
+		CampaignID: "77",
+		Creator:    "creator-token",
+		Members:    fake.NewPatreonMembers(10),
+		Codes:      map[string]string{"code-105": "105"},
+	}
+	patreonSrv := httptest.NewServer(fp.Handler())
+	t.Cleanup(patreonSrv.Close)
+
+	app := patreon.NewClient(fp.CampaignID, "client", "secret", "localhost", patreonSrv.URL, 0)
+	app.SetCreatorToken(&storage.Token{
+		Name:         storage.TokenBroadcaster,
+		AccessToken:  fp.Creator,
+		RefreshToken: "creator-refresh",
+		ExpiresAt:    time.Now().Add(30 * 24 * time.Hour),
+	})
+
+	ft := fake.NewTelegram(-100500)
+	svc, db := newOfflineService(t, core.Config{GraceSweeps: 1}, app, ft)
+
+	const tgID = 43
+	linkOffline(t, svc, ft, tgID, "code-105")
+
+	user, err := db.GetUserByTgId(tgID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.TwitchID != 105 {
+		t.Fatalf("linked patreon id %d, want 105", user.TwitchID)
+	}
+	if !ft.Member(tgID).CanSendMessages {
+		t.Fatal("patron must be allowed to send messages")
+	}
+
+	// pledge declined, sweep revoke rights
+	fp.SetStatus("105", "declined_patron")
+	checkRevoked(t, svc, db, ft, tgID)
+}
+
+func TestOfflineRoles(t *testing.T) {
+	fh := &fake.Helix{
+		BroadcasterID: "100",
+		Channel:       "leporel",
+		Moderator:     "mod-token",
+		Followers:     fake.NewFollowers(10),
+		Users:         map[string]string{},
+		Codes:         map[string]string{"code-5": "5", "code-6": "6"},
+		Mods:          []string{"5", "6"},
+	}
+	app := newOfflineTwitch(t, fh, "moderator:read:followers", "moderation:read")
+
+	roles, err := core.ParseRoles("moderator=Mod:delete_messages+pin_messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := telegram.ValidateRoles(roles); err != nil {
+		t.Fatal(err)
+	}
+
+	ft := fake.NewTelegram(-100500)
+	svc, _ := newOfflineService(t, core.Config{GraceSweeps: 1, Roles: roles}, app, ft)
+
+	const tgID = 44
+	linkOffline(t, svc, ft, tgID, "code-5")
+
+	// moderator appointed admin by owner before bot
+	const adminID = 45
+	linkOffline(t, svc, ft, adminID, "code-6")
+	ft.Admin(adminID)
+
+	if err := svc.CheckPermissions(false); err != nil {
+		t.Fatal(err)
+	}
+	member := ft.Member(tgID)
+	if member.Role != tb.Administrator || member.Title != "Mod" || !member.CanDeleteMessages {
+		t.Fatalf("moderator must be admin with title, got %+v", member)
+	}
+	member = ft.Member(adminID)
+	if member.Role != tb.Administrator || member.Title != "" || member.CanDeleteMessages {
+		t.Fatalf("admin appointed by owner must keep own rights, got %+v", member)
+	}
+
+	// moderator removed on twitch, demoted and keep member rights
+	fh.SetMods()
+	if err := svc.CheckPermissions(false); err != nil {
+		t.Fatal(err)
+	}
+	member = ft.Member(tgID)
+	if member.Role == tb.Administrator || !member.CanSendMessages {
+		t.Fatalf("former moderator must be demoted to member, got %+v", member)
+	}
+	if ft.Member(adminID).Role != tb.Administrator {
+		t.Fatal("admin appointed by owner must not be demoted")
+	}
+	for _, call := range ft.Calls("promoteChatMember") {
+		if call.Param("user_id") == fmt.Sprint(adminID) {
+			t.Fatalf("bot must not promote admin appointed by owner, got %v", call.Params)
+		}
+	}
+}
+
+func TestOfflineManualRestriction(t *testing.T) {
+	fh := &fake.Helix{
+		BroadcasterID: "100",
+		Channel:       "leporel",
+		Moderator:     "mod-token",
+		Followers:     fake.NewFollowers(10),
+		Users:         map[string]string{},
+		Codes:         map[string]string{"code-5": "5", "code-6": "6", "code-7": "7"},
+	}
+	app := newOfflineTwitch(t, fh)
+
+	ft := fake.NewTelegram(-100500)
+	svc, db := newOfflineService(t, core.Config{GraceSweeps: 1}, app, ft)
+
+	const muted, banned = 45, 46
+	linkOffline(t, svc, ft, muted, "code-5")
+	linkOffline(t, svc, ft, banned, "code-6")
+
+	// mod timeout and ban linked users, bot must not lift them
+	until := time.Now().Add(time.Hour)
+	ft.Restrict(muted, until)
+	ft.Ban(banned)
+
+	for _, tgID := range []int{muted, banned} {
+		if _, err := svc.Handle(core.CommandRegrant, core.Data{UserID: tgID, Actor: tgID, Reason: "already linked"}); err != nil {
+			t.Fatal(err)
+		}
+
+		entries, err := db.GetAudit(tgID, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Action != storage.AuditConflict {
+			t.Fatalf("conflict must be audited, got %+v", entries)
+		}
+	}
+
+	member := ft.Member(muted)
+	if member.Role != tb.Restricted || member.CanSendMessages || member.RestrictedUntil != until.Unix() {
+		t.Fatalf("manual timeout must be kept, got %+v", member)
+	}
+	if ft.Member(banned).Role != tb.Kicked {
+		t.Fatal("ban must be kept")
+	}
+
+	// admin muted member forever before bot changed his rights, mute kept after link
+	const silenced = 47
+	ft.Join(silenced)
+	ft.Restrict(silenced, time.Time{})
+	linkOffline(t, svc, ft, silenced, "code-7")
+
+	member = ft.Member(silenced)
+	if member.Role != tb.Restricted || member.CanSendMessages {
+		t.Fatalf("manual forever mute must be kept, got %+v", member)
+	}
+	entries, err := db.GetAudit(silenced, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflict := false
+	for _, e := range entries {
+		conflict = conflict || e.Action == storage.AuditConflict
+	}
+	if !conflict {
+		t.Fatalf("conflict must be audited, got %+v", entries)
+	}
+}
+
+func TestOfflineUnseenMembers(t *testing.T) {
+	fh := &fake.Helix{
+		BroadcasterID: "100",
+		Channel:       "leporel",
+		Moderator:     "mod-token",
+		Followers:     fake.NewFollowers(10),
+		Users:         map[string]string{},
+	}
+	app := newOfflineTwitch(t, fh)
+
+	// joined while bot was down, restricted on first message
+	ft := fake.NewTelegram(-100500)
+	svc, db := newOfflineService(t, core.Config{GraceSweeps: 1}, app, ft)
+
+	const late = 47
+	ft.Join(late)
+	ft.Push(fake.GroupMessage(ft.Group, late, "hello"))
+	if _, err := ft.Wait("restrictChatMember", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if ft.Member(late).CanSendMessages {
+		t.Fatal("unknown member must be restricted")
+	}
+
+	// first init grandfather members seen before start only
+	if err := db.SaveMember(&storage.Member{TelegramID: 48, SeenAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	svc = core.New(core.Config{Init: true}, app, fake.NewChat(), db)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	svc.Run(ctx)
+	white, err := db.GetWhiteListedUser(48)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if white.Description != "grandfathered" {
+		t.Fatalf("wrong whitelist description %q", white.Description)
+	}
+
+	if err := db.SaveMember(&storage.Member{TelegramID: 49, SeenAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.ReconcileMembers(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetWhiteListedUser(49); err == nil {
+		t.Fatal("member seen after init must not be grandfathered")
+	}
+}
+
+func TestOfflineInit(t *testing.T) {
+	fh := &fake.Helix{
+		BroadcasterID: "100",
+		Channel:       "leporel",
+		Moderator:     "mod-token",
+		Followers:     fake.NewFollowers(10),
+		Users:         map[string]string{},
+	}
+	app := newOfflineTwitch(t, fh)
+
+	ft := fake.NewTelegram(-100500)
+	_, db := newOfflineService(t, core.Config{GraceSweeps: 1}, app, ft)
+
+	// member seen before, group admin and members from csv
+	if err := db.SaveMember(&storage.Member{TelegramID: 50, SeenAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	ft.Join(51)
+	ft.Admin(51)
+	ft.AddFile("members", "tg_id\n52\n53\n")
+
+	const owner = 1000
+	ft.Push(fake.PrivateDocument(owner, "members", "members.csv", "/init"))
+
+	call, err := ft.Wait("sendMessage", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if call.Param("text") != "Grandfathered 4 members" {
+		t.Fatalf("wrong response %q", call.Param("text"))
+	}
+
+	for _, tgID := range []int{50, 51, 52, 53} {
+		white, err := db.GetWhiteListedUser(tgID)
+		if err != nil {
+			t.Fatalf("tg:%d: %v", tgID, err)
+		}
+		if white.Description != "grandfathered" {
+			t.Fatalf("tg:%d wrong description %q", tgID, white.Description)
+		}
+	}
+}
+
+func TestOfflineLeaver(t *testing.T) {
+	fh := &fake.Helix{
+		BroadcasterID: "100",
+		Channel:       "leporel",
+		Moderator:     "mod-token",
+		Followers:     fake.NewFollowers(10),
+		Users:         map[string]string{},
+		Codes:         map[string]string{"code-5": "5"},
+	}
+	app := newOfflineTwitch(t, fh)
+
+	ft := fake.NewTelegram(-100500)
+	svc, db := newOfflineService(t, core.Config{GraceSweeps: 1, LeaverExpiry: time.Millisecond}, app, ft)
+
+	const tgID = 55
+	linkOffline(t, svc, ft, tgID, "code-5")
+
+	ft.Leave(tgID)
+	ft.Push(fake.UserLeft(ft.Group, tgID))
+	for i := 0; ; i++ {
+		leavers, err := db.GetLeavers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, found := leavers[tgID]; found {
+			break
+		}
+		if i == 100 {
+			t.Fatal("leave not tracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// link expired, leaver unlinked without errors
+	time.Sleep(time.Millisecond)
+	if err := svc.ReconcileMembers(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetUserByTgId(tgID); err == nil {
+		t.Fatal("leaver must be unlinked")
+	}
+
+	// rejoined as unknown member and restricted
+	ft.Join(tgID)
+	ft.Push(fake.UserJoined(ft.Group, tgID))
+	for {
+		// skip grant on link
+		call, err := ft.Wait("restrictChatMember", 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if call.Params["can_send_messages"] != true {
+			break
+		}
+	}
+	if ft.Member(tgID).CanSendMessages {
+		t.Fatal("rejoined leaver must be restricted")
+	}
+}
+
+func TestOfflineRelink(t *testing.T) {
+	fh := &fake.Helix{
+		BroadcasterID: "100",
+		Channel:       "leporel",
+		Moderator:     "mod-token",
+		Followers:     fake.NewFollowers(10),
+		Users:         map[string]string{},
+		Codes:         map[string]string{"code-5": "5"},
+	}
+	app := newOfflineTwitch(t, fh)
+
+	ft := fake.NewTelegram(-100500)
+	svc, db := newOfflineService(t, core.Config{GraceSweeps: 1, RelinkCooldown: time.Hour}, app, ft)
+
+	const oldID, newID = 55, 56
+	linkOffline(t, svc, ft, oldID, "code-5")
+
+	// twitch account moved to new telegram account, old one restricted
+	authOffline(t, svc, ft, newID, "/relink", "code-5")
+	if user, err := db.GetUserByTgId(newID); err != nil || user.TwitchID != 5 {
+		t.Fatalf("account must be relinked: %v", err)
+	}
+	if _, err := db.GetUserByTgId(oldID); err == nil {
+		t.Fatal("old account must be unlinked")
+	}
+	if ft.Member(oldID).CanSendMessages {
+		t.Fatal("old account must be restricted")
+	}
+	if !ft.Member(newID).CanSendMessages {
+		t.Fatal("relinked account must be granted")
+	}
+
+	// old account can't take link back until cooldown end
+	ft.Push(fake.PrivateMessage(oldID, "/getlink"))
+	for {
+		call, err := ft.Wait("sendMessage", 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(call.Param("text"), "Link changed recently") {
+			break
+		}
+	}
+
+	// owner lift cooldown
+	const owner = 1000
+	ft.Push(fake.PrivateMessage(owner, "/unlink 55"))
+	for {
+		call, err := ft.Wait("sendMessage", 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(call.Param("text"), "cooldown lifted") {
+			break
+		}
+	}
+	ft.Push(fake.PrivateMessage(oldID, "/getlink"))
+	for {
+		call, err := ft.Wait("sendMessage", 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(call.Param("text"), "Link:") {
+			break
+		}
+	}
+}
+
+func TestOfflineForgetMe(t *testing.T) {
+	fh := &fake.Helix{
+		BroadcasterID: "100",
+		Channel:       "leporel",
+		Moderator:     "mod-token",
+		Followers:     fake.NewFollowers(10),
+		Users:         map[string]string{},
+		Codes:         map[string]string{"code-5": "5"},
+	}
+	app := newOfflineTwitch(t, fh)
+
+	ft := fake.NewTelegram(-100500)
+	svc, db := newOfflineService(t, core.Config{GraceSweeps: 1}, app, ft)
+
+	const tgID = 55
+	linkOffline(t, svc, ft, tgID, "code-5")
+
+	ft.Push(fake.PrivateMessage(tgID, "/mydata"))
+	call, err := ft.Wait("sendDocument", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data storage.UserData
+	if err := json.Unmarshal([]byte(call.Param("document")), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Link == nil || data.Link.TwitchID != 5 || len(data.Audit) == 0 {
+		t.Fatalf("wrong export %s", call.Param("document"))
+	}
+
+	// deleted only after confirmation
+	ft.Push(fake.PrivateMessage(tgID, "/forgetme"))
+	for {
+		call, err := ft.Wait("sendMessage", 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(call.Param("text"), "/forgetme confirm") {
+			break
+		}
+	}
+	if _, err := db.GetUserByTgId(tgID); err != nil {
+		t.Fatal("user must stay linked until confirmed")
+	}
+
+	ft.Push(fake.PrivateMessage(tgID, "/forgetme confirm"))
+	for {
+		call, err := ft.Wait("sendMessage", 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(call.Param("text"), "Your data deleted") {
+			break
+		}
+	}
+	if ft.Member(tgID).CanSendMessages {
+		t.Fatal("user must be restricted")
+	}
+	got, err := db.ExportUser(tgID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Link != nil || got.Member != nil || len(got.Audit) != 0 {
+		t.Fatalf("user data must be deleted, got %+v", got)
+	}
+
+	// bot lift own restriction when user link again
+	linkOffline(t, svc, ft, tgID, "code-5")
+	if !ft.Member(tgID).CanSendMessages {
+		t.Fatal("linked again user must get rights back")
+	}
+	entries, err := db.GetAudit(tgID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Action == storage.AuditConflict {
+			t.Fatalf("restriction of bot must not be taken as manual: %v", e.String())
+		}
+	}
+}
+
+func TestNotifyMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		notify   string
+		explicit bool
+		owner    int
+		want     core.NotifyMode
+		wantErr  bool
+	}{
+		{name: "default with owner", notify: "digest", owner: 1, want: core.NotifyDigest},
+		{name: "default without owner", notify: "digest", want: core.NotifyOff},
+		{name: "explicit without owner", notify: "event", explicit: true, wantErr: true},
+		{name: "explicit off without owner", notify: "off", explicit: true, want: core.NotifyOff},
+		{name: "unknown mode", notify: "often", explicit: true, owner: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := notifyMode(tt.notify, tt.explicit, tt.owner)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseFounders(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "123", want: []string{"123"}},
+		{in: "123, 456,", want: []string{"123", "456"}},
+		{in: "leporel", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseFounders(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%q: error %v, want error %v", tt.in, err, tt.wantErr)
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
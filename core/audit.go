@@ -0,0 +1,85 @@
+package core
+
+import (
+	"bytes"
+	"encoding/csv"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leporel/ttg/storage"
+)
+
+// audit append entry to audit log, errors only logged to not break rights change
+func (b *Service) audit(action storage.AuditAction, tgID, twID, actor int, reason string) {
+	err := b.db.AddAudit(&storage.AuditEntry{
+		Action:     action,
+		TelegramID: tgID,
+		TwitchID:   twID,
+		Actor:      actor,
+		Reason:     reason,
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		log.Println("ERROR [AUDIT]: ", err)
+	}
+}
+
+// auditLog return last entries as text, filtered by telegram id if tgID not 0
+func (b *Service) auditLog(tgID int, limit int) (string, error) {
+	entries, err := b.db.GetAudit(tgID, limit)
+	if err != nil {
+		return "", err
+	}
+
+	if len(entries) == 0 {
+		return "Audit log is empty", nil
+	}
+
+	lines := make([]string, 0, len(entries))
+	for _, e := range entries {
+		lines = append(lines, e.String())
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// auditExport return full audit log in csv format
+func (b *Service) auditExport() (string, error) {
+	entries, err := b.db.GetAudit(0, 0)
+	if err != nil {
+		return "", err
+	}
+
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+
+	err = w.Write([]string{"id", "created_at", "action", "tg_id", "twitch_id", "actor", "reason"})
+	if err != nil {
+		return "", err
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		err = w.Write([]string{
+			strconv.Itoa(e.ID),
+			e.CreatedAt.Format(time.RFC3339),
+			string(e.Action),
+			strconv.Itoa(e.TelegramID),
+			strconv.Itoa(e.TwitchID),
+			strconv.Itoa(e.Actor),
+			e.Reason,
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	w.Flush()
+	if err = w.Error(); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
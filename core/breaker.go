@@ -0,0 +1,47 @@
+package core
+
+import (
+	"fmt"
+	"log"
+)
+
+// breakerTripped protect from mass revocation when provider return truncated or empty list of eligible users,
+// breaker ignore sweeps with less than min revocations, threshold <= 0 disable breaker
+func breakerTripped(total, revoke, followers int, threshold float64, min int) bool {
+	if threshold <= 0 || total == 0 || revoke == 0 {
+		return false
+	}
+	if followers == 0 {
+		return true
+	}
+	if revoke < min {
+		return false
+	}
+
+	return float64(revoke)/float64(total) > threshold
+}
+
+// tripBreaker abort sweep and ask owner to confirm it by command, owner alerted once until breaker reset
+func (b *Service) tripBreaker(total, revoke, followers int) error {
+	if !b.breakerTripped {
+		msg := fmt.Sprintf("Sweep aborted: %d of %d linked users not eligible on %s (%d eligible users in total). "+
+			"If it is correct, send /confirmsweep to proceed", revoke, total, b.app.Name(), followers)
+
+		log.Println(msg)
+		b.chat.SendOwner(msg)
+	}
+	b.breakerTripped = true
+
+	return fmt.Errorf("circuit breaker tripped, %d of %d users to revoke", revoke, total)
+}
+
+// confirmSweep run sweep ignoring breaker, must not be called under b.mu
+func (b *Service) confirmSweep() {
+	if err := b.CheckPermissions(true); err != nil {
+		log.Println("ERROR: ", err)
+		b.chat.SendOwner(fmt.Sprintf("Confirmed sweep failed: %v", err))
+		return
+	}
+
+	b.chat.SendOwner("Confirmed sweep done")
+}
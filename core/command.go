@@ -0,0 +1,57 @@
+package core
+
+import (
+	"github.com/leporel/ttg/storage"
+)
+
+// Command request from chat to service
+type Command int
+
+const (
+	CommandGetLink Command = iota
+	CommandAddWhiteList
+	CommandCheckWhiteList
+	CommandCheckUser
+	CommandSeenUser
+	CommandResolveUsername
+	CommandAudit
+	CommandAuditLog
+	CommandAuditExport
+	CommandConfirmSweep
+	CommandConnect
+	CommandCapabilities
+	// CommandRestrict apply restricted profile to not linked user, e.g. joined group
+	CommandRestrict
+	// CommandRegrant apply profile of linked or whitelisted user again
+	CommandRegrant
+	// CommandLeftUser user left group
+	CommandLeftUser
+	// CommandUnlink unlink user by himself with cooldown, or by owner (Actor) without
+	CommandUnlink
+	// CommandRelink link user to other account, replacing his link and link of account
+	CommandRelink
+	// CommandInit grandfather seen members unknown to bot and UserIDs (admins, imported ids)
+	CommandInit
+	// CommandMyData export everything stored about user as json
+	CommandMyData
+	// CommandForgetMe delete everything stored about user and restrict him
+	CommandForgetMe
+)
+
+// Data command payload
+type Data struct {
+	UserID   int
+	Username string
+	// Language code of sender client, e.g. telegram language_code
+	Language string
+	// UserIDs users of bulk commands
+	UserIDs []int
+
+	Actor  int
+	Action storage.AuditAction
+	Reason string
+}
+
+// Handler process command and return response for user,
+// check commands respond "exist" or "none"
+type Handler func(command Command, payload Data) (string, error)
@@ -0,0 +1,52 @@
+package core
+
+import "time"
+
+type RestrictMode int
+
+const (
+	RestrictFollowers   RestrictMode = iota
+	RestrictSubscribers              // TODO
+)
+
+// Config gating options
+type Config struct {
+	// Init first init, members seen by bot before start and unknown to it are grandfathered to whitelist once on Run,
+	// members seen after restricted as usual
+	Init bool
+
+	Restrict RestrictMode
+
+	// User revoked after GraceSweeps failed sweeps or GracePeriod since first fail
+	GraceSweeps int
+	GracePeriod time.Duration
+
+	// Abort sweep if more than BreakerThreshold of linked users (at least BreakerMin) to revoke
+	BreakerThreshold float64
+	BreakerMin       int
+
+	// RelinkCooldown accounts can't be linked again for this period after /unlink or /relink, 0 to disable
+	RelinkCooldown time.Duration
+
+	// LeaverExpiry unlink users who left group after this period, 0 to keep them linked
+	LeaverExpiry time.Duration
+
+	// Profiles chat permissions of restricted and entitled users
+	Profiles Profiles
+
+	// Roles given in chat by user status, by priority, empty to not manage roles
+	Roles []Role
+
+	// Messages texts sent to users and shown on callback pages, built-in catalogue if nil
+	Messages *Messages
+
+	// Pages html template of callback pages, embedded default if nil
+	Pages *Pages
+
+	// Notify owner notifications mode, DigestInterval period of NotifyDigest, DefaultDigestInterval if not positive
+	Notify         NotifyMode
+	DigestInterval time.Duration
+
+	// Listen address of oauth2 callback server, empty to not listen
+	Addr string
+}
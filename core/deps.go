@@ -0,0 +1,121 @@
+package core
+
+import (
+	"errors"
+	"time"
+
+	"github.com/leporel/ttg/storage"
+)
+
+// Dependencies of Service, implemented by twitch.Client or patreon.Client, telegram.Bot and storage.Storage,
+// replaced by own implementations to embed service or by mocks in tests
+
+// Identity user account in identity provider
+type Identity struct {
+	// ID numeric id of user in provider
+	ID   string
+	Name string
+}
+
+// IdentityProvider verify user identity and entitlement (e.g. twitch follow, active patreon pledge),
+// one provider per service
+type IdentityProvider interface {
+	// Name shown to users, e.g. "Twitch"
+	Name() string
+	// GetAuthLink oauth link for user, state returned to callback
+	GetAuthLink(state string) (string, error)
+	// Authorize exchange callback code to user access token and identity
+	Authorize(code string) (string, *Identity, error)
+	// Entitled check user entitlement by his own token
+	Entitled(token string, user *Identity) (bool, error)
+	// GetEntitled return map[userID]name of entitled users among ids and count of all entitled users,
+	// count used by circuit breaker
+	GetEntitled(ids []string) (map[string]string, int, error)
+
+	// GetConnectLink oauth link for channel owner, to give bot access to entitled users list
+	GetConnectLink(state string) string
+	Connect(code string) (*storage.Token, error)
+	CapabilitiesReport() string
+	ValidateTokens() error
+}
+
+// ChatPlatform enforce entitlement in chat and message users, commands from chat passed to handler.
+// Telegram group is reference implementation (telegram.Bot), other platforms map profiles to own rights,
+// e.g. Discord remove member role, Matrix lower power level below events_default for muted profile.
+// User ids are numeric, platforms with string ids keep own mapping to int
+type ChatPlatform interface {
+	// Name shown in logs, e.g. "Telegram"
+	Name() string
+	// Start receive chat updates until Stop
+	Start(handler Handler)
+	Stop()
+	// SetRights apply restriction profile to user, e.g. read only for not eligible users.
+	// owned is last profile applied by bot, nil if unknown, restrictions and bans made by chat admins
+	// must not be changed, ErrManualRestriction returned instead
+	SetRights(userID int, profile Profile, owned *Profile) error
+	SendUser(userID int, msg interface{}, options ...interface{})
+	SendOwner(msg interface{}, options ...interface{})
+}
+
+// ErrManualRestriction user restricted or banned by chat admin, bot does not change his rights
+var ErrManualRestriction = errors.New("user restricted by chat admin")
+
+// ErrNotMember returned by ChatPlatform.SetRights and RoleSetter.SetRole for users not in chat
+var ErrNotMember = errors.New("user not in chat")
+
+// ErrChatAdmin returned by ChatPlatform.SetRights for chat admins and owner, they can not be restricted
+var ErrChatAdmin = errors.New("user is chat admin")
+
+// Store persist linked users, whitelist, members, leavers, audit and grace state,
+// getters return sql.ErrNoRows if nothing found
+type Store interface {
+	AddWhiteList(user *storage.WhiteListedUser) error
+	GetWhiteListedUser(tgID int) (*storage.WhiteListedUser, error)
+
+	AddUser(user *storage.User) error
+	GetUserByTgId(tgID int) (*storage.User, error)
+	GetUserByTwId(twID int) (*storage.User, error)
+	DeleteUser(tgID int) error
+	// RelinkUser replace links of user telegram and twitch accounts in one transaction, return replaced links
+	RelinkUser(user *storage.User) ([]storage.User, error)
+	GetUsers() (map[string]int, error)
+
+	SaveMember(member *storage.Member) error
+	GetMemberByUsername(username string) (*storage.Member, error)
+	GetMembers() (map[int]*storage.Member, error)
+
+	SaveCooldown(account string, until time.Time) error
+	GetCooldown(account string) (time.Time, error)
+	DeleteCooldown(account string) error
+
+	SaveLeaver(tgID int, leftAt time.Time) error
+	DeleteLeaver(tgID int) error
+	GetLeavers() (map[int]time.Time, error)
+
+	AddAudit(entry *storage.AuditEntry) error
+	GetAudit(tgID int, limit int) ([]storage.AuditEntry, error)
+
+	SaveGrace(g *storage.Grace) error
+	DeleteGrace(tgID int) error
+	GetGraces() (map[int]*storage.Grace, error)
+
+	SaveRole(tgID int, status string) error
+	DeleteRole(tgID int) error
+	GetRoles() (map[int]string, error)
+
+	SaveProfile(tgID int, name string) error
+	GetProfile(tgID int) (string, error)
+	DeleteProfile(tgID int) error
+	GetProfiles() (map[int]string, error)
+
+	SaveLanguage(tgID int, language string) error
+	GetLanguage(tgID int) (string, error)
+
+	// ExportUser collect everything stored about user
+	ExportUser(tgID int) (*storage.UserData, error)
+	// ForgetUser delete everything stored about user, return count of deleted audit entries
+	// and anonymised entries of other users made by user or mentioning him
+	ForgetUser(tgID int) (int, int, error)
+}
+
+var _ Store = (*storage.Storage)(nil)
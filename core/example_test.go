@@ -0,0 +1,52 @@
+package core_test
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/leporel/ttg/core"
+	"github.com/leporel/ttg/storage"
+	"github.com/leporel/ttg/telegram"
+	"github.com/leporel/ttg/twitch"
+)
+
+// Service embedded in own application: callback mounted on own http server,
+// any ChatPlatform can be used instead of telegram.Bot (see internal/fake.Chat), its commands passed to Service.Handle
+func ExampleNew() {
+	db, err := storage.New("db.sqlite")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	app, err := twitch.NewClient("channel", "app id", "app secret", "example.com", "", "")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	app.SetTokenSaver(db.SaveToken)
+
+	tg, err := telegram.NewBot("bot token", "", -100123, 7007777, "example.com")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	svc := core.New(core.Config{
+		GraceSweeps:      3,
+		BreakerThreshold: 0.2,
+		BreakerMin:       3,
+		Notify:           core.NotifyEvent,
+	}, app, tg, db)
+
+	handler, err := svc.CallbackHandler()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/auth/callback", handler)
+	go func() {
+		log.Println(http.ListenAndServe(":8444", mux))
+	}()
+
+	svc.Run(context.Background())
+}
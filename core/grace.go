@@ -0,0 +1,53 @@
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/leporel/ttg/storage"
+)
+
+// graceExpired decide if user still not eligible after configured sweeps or period,
+// zero threshold is disabled, if both disabled user revoked immediately
+func graceExpired(g *storage.Grace, sweeps int, period time.Duration, now time.Time) bool {
+	if sweeps <= 1 && period <= 0 {
+		return true
+	}
+	if sweeps > 1 && g.Strikes >= sweeps {
+		return true
+	}
+	if period > 0 && now.Sub(g.FirstFailedAt) >= period {
+		return true
+	}
+
+	return false
+}
+
+// strike register failed sweep for user, warn him on first one,
+// return true if grace is over and user must be revoked
+func (b *Service) strike(tgID, twID int, g *storage.Grace) (bool, error) {
+	now := time.Now()
+
+	if g == nil {
+		g = &storage.Grace{
+			TelegramID:    tgID,
+			FirstFailedAt: now,
+		}
+	}
+	g.Strikes++
+
+	if graceExpired(g, b.cfg.GraceSweeps, b.cfg.GracePeriod, now) {
+		return true, nil
+	}
+
+	if err := b.db.SaveGrace(g); err != nil {
+		return false, err
+	}
+
+	if g.Strikes == 1 {
+		b.chat.SendUser(tgID, b.userText(tgID, MsgGraceWarning, Args{"Provider": b.app.Name()}))
+		b.audit(storage.AuditWarn, tgID, twID, storage.ActorBot, fmt.Sprintf("not eligible on %s, strike %d", b.app.Name(), g.Strikes))
+	}
+
+	return false, nil
+}
@@ -0,0 +1,294 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leporel/ttg/storage"
+)
+
+// grandfathered whitelist description of members who were in group before bot
+const grandfathered = "grandfathered"
+
+// ReconcileMembers check every member seen in group, members unknown to bot are restricted.
+// Leavers unlinked after cfg.LeaverExpiry
+func (b *Service) ReconcileMembers() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	leavers, err := b.db.GetLeavers()
+	if err != nil {
+		b.notify.add(NotifyError, "leavers: %v", err)
+		return err
+	}
+	b.expireLeavers(leavers)
+
+	members, err := b.db.GetMembers()
+	if err != nil {
+		b.notify.add(NotifyError, "members: %v", err)
+		return err
+	}
+
+	for tgID := range members {
+		if _, left := leavers[tgID]; left {
+			continue
+		}
+		if err := b.reconcileMember(tgID); err != nil {
+			log.Println("ERROR: ", err)
+			b.notify.add(NotifyError, "member tg:%d: %v", tgID, err)
+		}
+	}
+
+	return nil
+}
+
+// seenMember reconcile member who wrote in group, checked once per cache expiration to not query db on every message
+func (b *Service) seenMember(tgID int) error {
+	key := fmt.Sprintf("member:%d", tgID)
+	if _, found := b.cache.Get(key); found {
+		return nil
+	}
+
+	if err := b.reconcileMember(tgID); err != nil {
+		return err
+	}
+	b.cache.SetDefault(key, true)
+
+	return nil
+}
+
+// reconcileMember restrict member not linked, not whitelisted and never restricted by bot,
+// e.g. joined while bot was down
+func (b *Service) reconcileMember(tgID int) error {
+	known, err := b.checkUserTelegram(tgID)
+	if err != nil || known {
+		return err
+	}
+	known, err = b.checkWhiteList(tgID)
+	if err != nil || known {
+		return err
+	}
+
+	_, err = b.db.GetProfile(tgID)
+	if err == nil {
+		// bot already set his rights
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	applied, err := b.setRights(tgID, 0, b.cfg.Profiles.restricted())
+	if errors.Is(err, ErrChatAdmin) || errors.Is(err, ErrNotMember) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if applied {
+		log.Printf("Unknown member [%d], set restrict\n", tgID)
+		b.audit(storage.AuditRestrict, tgID, 0, storage.ActorBot, "unknown member")
+	}
+
+	return nil
+}
+
+// leave remember when user left group, linked users unlinked after cfg.LeaverExpiry.
+// Chat admins lose rights on leave, so role forgotten
+func (b *Service) leave(tgID int) error {
+	if err := b.db.SaveLeaver(tgID, time.Now()); err != nil {
+		return err
+	}
+	if err := b.db.DeleteRole(tgID); err != nil {
+		return err
+	}
+	b.audit(storage.AuditLeave, tgID, 0, storage.ActorBot, "left group")
+
+	return nil
+}
+
+// expireLeavers unlink users left group more than cfg.LeaverExpiry ago, they restricted as unknown members on rejoin
+func (b *Service) expireLeavers(leavers map[int]time.Time) {
+	if b.cfg.LeaverExpiry <= 0 {
+		return
+	}
+
+	for tgID, leftAt := range leavers {
+		if time.Since(leftAt) < b.cfg.LeaverExpiry {
+			continue
+		}
+
+		if err := b.expireLeaver(tgID); err != nil {
+			log.Println("ERROR: ", err)
+			b.notify.add(NotifyError, "leaver tg:%d: %v", tgID, err)
+		}
+	}
+}
+
+// expireLeaver unlink leaver, he stay leaver until seen in group again
+func (b *Service) expireLeaver(tgID int) error {
+	user, err := b.db.GetUserByTgId(tgID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Leaver [%d] unlinked\n", tgID)
+	err = b.db.DeleteUser(tgID)
+	if err == sql.ErrNoRows {
+		log.Printf("Leaver [%d] already unlinked\n", tgID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := b.db.DeleteGrace(tgID); err != nil {
+		return err
+	}
+	b.audit(storage.AuditUnlink, tgID, user.TwitchID, storage.ActorBot, "left group")
+	b.notify.add(NotifyRevoke, "tg:%d tw:%d (left group)", tgID, user.TwitchID)
+
+	return nil
+}
+
+// initSeenMembers grandfather members seen by bot before start, once in init mode.
+// Members seen after restricted as usual, admins and imported ids added by /init
+func (b *Service) initSeenMembers() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	count, err := b.initMembers(nil, storage.ActorBot)
+	if err != nil {
+		return err
+	}
+	log.Printf("First init, %d members grandfathered\n", count)
+
+	return nil
+}
+
+// initMembers grandfather members seen in group and unknown to bot, and given users (admins, imported ids),
+// return count of grandfathered
+func (b *Service) initMembers(ids []int, actor int) (int, error) {
+	members, err := b.db.GetMembers()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for tgID := range members {
+		// new members restricted by bot on join
+		_, err := b.db.GetProfile(tgID)
+		if err == nil {
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return count, err
+		}
+
+		ok, err := b.grandfather(tgID, actor)
+		if err != nil {
+			return count, err
+		}
+		if ok {
+			count++
+		}
+	}
+
+	for _, tgID := range ids {
+		ok, err := b.grandfather(tgID, actor)
+		if err != nil {
+			return count, err
+		}
+		if ok {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// grandfather whitelist member who was in group before bot, restriction set by bot lifted.
+// Linked and whitelisted users are skipped
+func (b *Service) grandfather(tgID, actor int) (bool, error) {
+	known, err := b.checkUserTelegram(tgID)
+	if err != nil || known {
+		return false, err
+	}
+	known, err = b.checkWhiteList(tgID)
+	if err != nil || known {
+		return false, err
+	}
+
+	err = b.db.AddWhiteList(&storage.WhiteListedUser{
+		TelegramID:  tgID,
+		Description: grandfathered,
+	})
+	if err != nil {
+		return false, err
+	}
+	log.Printf("Member [%d] grandfathered\n", tgID)
+	b.audit(storage.AuditWhiteList, tgID, 0, actor, grandfathered)
+
+	_, err = b.db.GetProfile(tgID)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	if err != nil {
+		return true, err
+	}
+
+	applied, err := b.setRights(tgID, 0, b.cfg.Profiles.entitled(nil))
+	if errors.Is(err, ErrChatAdmin) || errors.Is(err, ErrNotMember) {
+		return true, nil
+	}
+	if err != nil {
+		return true, err
+	}
+	if applied {
+		b.audit(storage.AuditGrant, tgID, 0, actor, grandfathered)
+	}
+
+	return true, nil
+}
+
+// ParseMemberIDs read telegram user ids from first column of csv, header row and empty rows skipped
+func ParseMemberIDs(r io.Reader) ([]int, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var ids []int
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		s := strings.TrimSpace(record[0])
+		if s == "" {
+			continue
+		}
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: wrong user id %q", line, s)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,242 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leporel/ttg/storage"
+)
+
+func TestService_ReconcileMembers(t *testing.T) {
+	tests := []struct {
+		name        string
+		init        bool
+		prepare     func(chat *mockChat, store *mockStore)
+		wantProfile string
+		wantWhite   bool
+	}{
+		{
+			name:        "unknown member restricted",
+			wantProfile: "read_only",
+		},
+		{
+			name: "linked user skipped",
+			prepare: func(_ *mockChat, store *mockStore) {
+				store.users[1] = &storage.User{TelegramID: 1, TwitchID: 101, CreatedAt: time.Now()}
+			},
+		},
+		{
+			name: "whitelisted user skipped",
+			prepare: func(_ *mockChat, store *mockStore) {
+				store.whitelist[1] = &storage.WhiteListedUser{TelegramID: 1}
+			},
+			wantWhite: true,
+		},
+		{
+			name: "rights set by bot skipped",
+			prepare: func(_ *mockChat, store *mockStore) {
+				store.profiles[1] = "read_only"
+			},
+		},
+		{
+			name: "chat admin skipped",
+			prepare: func(chat *mockChat, _ *mockStore) {
+				chat.roles[1] = &Role{Title: "Admin"}
+			},
+		},
+		{
+			// init snapshot taken once on start, not in sweeps
+			name:        "unknown member restricted in init mode",
+			init:        true,
+			wantProfile: "read_only",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot, chat, store := newMockService(&mockIdentity{})
+			bot.cfg.Init = tt.init
+			store.members[1] = &storage.Member{TelegramID: 1, SeenAt: time.Now()}
+			if tt.prepare != nil {
+				tt.prepare(chat, store)
+			}
+
+			if err := bot.ReconcileMembers(); err != nil {
+				t.Fatal(err)
+			}
+
+			if chat.profiles[1] != tt.wantProfile {
+				t.Fatalf("profile %q, want %q", chat.profiles[1], tt.wantProfile)
+			}
+			if _, found := store.whitelist[1]; found != tt.wantWhite {
+				t.Fatalf("whitelisted %v, want %v", found, tt.wantWhite)
+			}
+		})
+	}
+}
+
+func TestService_seenMember(t *testing.T) {
+	bot, chat, store := newMockService(&mockIdentity{})
+
+	// joined while bot was down, restricted on first message
+	if _, err := bot.Handle(CommandSeenUser, Data{UserID: 1, Username: "late"}); err != nil {
+		t.Fatal(err)
+	}
+	if chat.profiles[1] != "read_only" {
+		t.Fatalf("unknown member must be restricted, got %q", chat.profiles[1])
+	}
+
+	// next messages not reconciled until cache expired
+	delete(chat.profiles, 1)
+	delete(store.profiles, 1)
+	if _, err := bot.Handle(CommandSeenUser, Data{UserID: 1, Username: "late"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := chat.profiles[1]; found {
+		t.Fatal("member must be checked once")
+	}
+}
+
+func TestParseMemberIDs(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []int
+		wantErr bool
+	}{
+		{in: "1\n2\n3", want: []int{1, 2, 3}},
+		{in: "tg_id,username\n1,first\n\n2,second\n", want: []int{1, 2}},
+		{in: "1\n 7 ;", wantErr: true},
+		{in: "1\nsecond", wantErr: true},
+		{in: "", want: nil},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseMemberIDs(strings.NewReader(tt.in))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: error %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestService_initMembers(t *testing.T) {
+	bot, chat, store := newMockService(&mockIdentity{})
+
+	// seen before bot, joined and restricted by bot, linked and imported
+	store.members[1] = &storage.Member{TelegramID: 1, SeenAt: time.Now()}
+	store.members[2] = &storage.Member{TelegramID: 2, SeenAt: time.Now()}
+	store.profiles[2] = "read_only"
+	store.users[3] = &storage.User{TelegramID: 3, TwitchID: 103, CreatedAt: time.Now()}
+	store.profiles[4] = "read_only"
+
+	response, err := bot.Handle(CommandInit, Data{UserIDs: []int{3, 4, 5}, Actor: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response != "Grandfathered 3 members" {
+		t.Fatalf("wrong response %q", response)
+	}
+
+	for _, tgID := range []int{1, 4, 5} {
+		if w := store.whitelist[tgID]; w == nil || w.Description != grandfathered {
+			t.Fatalf("tg:%d must be grandfathered", tgID)
+		}
+	}
+	for _, tgID := range []int{2, 3} {
+		if _, found := store.whitelist[tgID]; found {
+			t.Fatalf("tg:%d must not be grandfathered", tgID)
+		}
+	}
+	if chat.profiles[4] != "full" {
+		t.Fatalf("imported member restricted by bot must be granted, got %q", chat.profiles[4])
+	}
+	if _, found := chat.profiles[1]; found {
+		t.Fatal("rights of seen member must not be changed")
+	}
+}
+
+func TestService_initSeenMembers(t *testing.T) {
+	bot, chat, store := newMockService(&mockIdentity{})
+	bot.cfg.Init = true
+	store.members[1] = &storage.Member{TelegramID: 1, SeenAt: time.Now()}
+
+	if err := bot.initSeenMembers(); err != nil {
+		t.Fatal(err)
+	}
+	if w := store.whitelist[1]; w == nil || w.Description != grandfathered {
+		t.Fatal("member seen before start must be grandfathered")
+	}
+
+	// joined after snapshot
+	if _, err := bot.Handle(CommandSeenUser, Data{UserID: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := store.whitelist[2]; found || chat.profiles[2] != "read_only" {
+		t.Fatalf("member seen after init must be restricted, got %q", chat.profiles[2])
+	}
+}
+
+func TestService_leavers(t *testing.T) {
+	tests := []struct {
+		name       string
+		expiry     time.Duration
+		leftAgo    time.Duration
+		rejoined   bool
+		wantLinked bool
+	}{
+		{name: "kept linked without expiry", leftAgo: 48 * time.Hour, wantLinked: true},
+		{name: "kept linked before expiry", expiry: 24 * time.Hour, leftAgo: time.Hour, wantLinked: true},
+		{name: "unlinked after expiry", expiry: 24 * time.Hour, leftAgo: 48 * time.Hour},
+		{name: "rejoined before expiry", expiry: 24 * time.Hour, leftAgo: 48 * time.Hour, rejoined: true, wantLinked: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot, chat, store := newMockService(&mockIdentity{})
+			bot.cfg.LeaverExpiry = tt.expiry
+			bot.notify = newNotifier(NotifyEvent, func(msg string) {
+				chat.SendOwner(msg)
+			})
+			store.users[1] = &storage.User{TelegramID: 1, TwitchID: 101, CreatedAt: time.Now()}
+			store.roles[1] = "vip"
+			store.members[1] = &storage.Member{TelegramID: 1, SeenAt: time.Now()}
+
+			if _, err := bot.Handle(CommandLeftUser, Data{UserID: 1}); err != nil {
+				t.Fatal(err)
+			}
+			if _, found := store.roles[1]; found {
+				t.Fatal("role of leaver must be forgotten")
+			}
+			store.leavers[1] = time.Now().Add(-tt.leftAgo)
+
+			if tt.rejoined {
+				if _, err := bot.Handle(CommandSeenUser, Data{UserID: 1}); err != nil {
+					t.Fatal(err)
+				}
+				if _, found := store.leavers[1]; found {
+					t.Fatal("rejoined user must not be leaver")
+				}
+			}
+
+			// leaver not in chat, reconciled silently
+			chat.absent[1] = !tt.rejoined
+			if err := bot.ReconcileMembers(); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := store.GetUserByTgId(1); (err == nil) != tt.wantLinked {
+				t.Fatalf("linked %v, want %v", err == nil, tt.wantLinked)
+			}
+			for _, msg := range chat.owner {
+				if strings.HasPrefix(msg, "Errors") {
+					t.Fatalf("sweep of leaver must not fail: %v", msg)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Message key of localised text in Messages
+type Message string
+
+// Messages sent to users and shown on callback pages, templates get Args.
+// Link message is telegram html, other texts are plain
+const (
+	// MsgLink oauth link, Link
+	MsgLink Message = "link"
+	// MsgRightsFull, MsgRightsRestricted and MsgRightsChanged sent when profile applied, Profile
+	MsgRightsFull       Message = "rights_full"
+	MsgRightsRestricted Message = "rights_restricted"
+	MsgRightsChanged    Message = "rights_changed"
+	// MsgRole user promoted, Role
+	MsgRole Message = "role"
+	// MsgLinkedRestricted user linked, but restricted by chat admin
+	MsgLinkedRestricted Message = "linked_restricted"
+	// MsgGraceWarning user not eligible in sweep, Provider
+	MsgGraceWarning  Message = "grace_warning"
+	MsgAlreadyLinked Message = "already_linked"
+	MsgNotLinked     Message = "not_linked"
+	MsgUnlinked      Message = "unlinked"
+	MsgWhiteListed   Message = "white_listed"
+	// MsgCooldown link of account changed recently, Until
+	MsgCooldown      Message = "cooldown"
+	MsgForgetConfirm Message = "forget_confirm"
+	MsgForgotten     Message = "forgotten"
+	MsgError         Message = "error"
+	MsgNotReady      Message = "not_ready"
+
+	MsgPageLinked        Message = "page_linked"
+	MsgPageRelinked      Message = "page_relinked"
+	MsgPageAlreadyLinked Message = "page_already_linked"
+	// MsgPageNotEligible Provider
+	MsgPageNotEligible Message = "page_not_eligible"
+	MsgPageConnected   Message = "page_connected"
+	MsgPageError       Message = "page_error"
+	// MsgPageTelegramLinked, MsgPageAccountTaken and MsgPageCancelled Provider
+	MsgPageTelegramLinked Message = "page_telegram_linked"
+	MsgPageAccountTaken   Message = "page_account_taken"
+	MsgPageCancelled      Message = "page_cancelled"
+	MsgPageBadRequest     Message = "page_bad_request"
+	MsgPageExpired        Message = "page_expired"
+	MsgPageTooMany        Message = "page_too_many"
+	// MsgPageOpenBot text of link back to bot
+	MsgPageOpenBot Message = "page_open_bot"
+	// MsgTitleSuccess, MsgTitleDenied and MsgTitleError titles of pages by outcome kind
+	MsgTitleSuccess Message = "title_success"
+	MsgTitleDenied  Message = "title_denied"
+	MsgTitleError   Message = "title_error"
+)
+
+// Args named values of message template
+type Args map[string]interface{}
+
+var defaultMessages = map[string]map[Message]string{
+	"en": {
+		MsgLink:             "Link: <a href=\"{{.Link}}\">click me</a>\n\nLink live 10 minutes, after time expired, your need to get one new",
+		MsgRightsFull:       "Now you can send message in group",
+		MsgRightsRestricted: "You rights has been restricted in group",
+		MsgRightsChanged:    "Your rights in group changed: {{.Profile}}",
+		MsgRole:             "You are {{.Role}} in group now",
+		MsgLinkedRestricted: "Your account linked, but you are restricted by group admins, ask them to lift restriction",
+		MsgGraceWarning: "You are not eligible on {{.Provider}} anymore. " +
+			"If you unfollowed or cancelled membership, renew it, otherwise your rights in group will be restricted soon",
+		MsgAlreadyLinked: "you already linked to group",
+		MsgNotLinked:     "You are not linked",
+		MsgUnlinked:      "Your account unlinked",
+		MsgWhiteListed:   "You are in white list!",
+		MsgCooldown:      "Link changed recently, try again after {{.Until}}",
+		MsgForgetConfirm: "All data stored about you will be deleted (/mydata to see it), " +
+			"you will be restricted in group until you link account again. Send /forgetme confirm to proceed",
+		MsgForgotten: "Your data deleted, you are restricted in group until you link account again",
+		MsgError:     "Error happen! 😟",
+		MsgNotReady:  "Bot not ready",
+
+		MsgPageLinked:        "Authorization successful, bot will soon give to you rights",
+		MsgPageRelinked:      "Authorization successful, account relinked",
+		MsgPageAlreadyLinked: "Authorization successful, account already linked",
+		MsgPageNotEligible:   "Authorization successful, but you are not eligible on {{.Provider}}",
+		MsgPageConnected:     "Authorization successful, bot connected to channel",
+		MsgPageError:         "Something went wrong, try again later",
+		MsgPageTelegramLinked: "Your telegram account is already linked, " +
+			"send /relink to bot to link other {{.Provider}} account",
+		MsgPageAccountTaken: "This {{.Provider}} account is linked to other telegram account, " +
+			"send /relink to bot to move it to this one",
+		MsgPageCancelled:  "Authorization on {{.Provider}} cancelled, get new link with /getlink to try again",
+		MsgPageBadRequest: "Wrong link, get new one with /getlink",
+		MsgPageExpired:    "Link expired, get new one with /getlink",
+		MsgPageTooMany:    "Too many requests, try again in a minute",
+		MsgPageOpenBot:    "Open bot",
+		MsgTitleSuccess:   "Done",
+		MsgTitleDenied:    "Access denied",
+		MsgTitleError:     "Error",
+	},
+	"ru": {
+		MsgLink:             "Ссылка: <a href=\"{{.Link}}\">нажми</a>\n\nСсылка действует 10 минут, после этого получи новую",
+		MsgRightsFull:       "Теперь ты можешь писать в группе",
+		MsgRightsRestricted: "Твои права в группе ограничены",
+		MsgRightsChanged:    "Твои права в группе изменены: {{.Profile}}",
+		MsgRole:             "Теперь ты {{.Role}} в группе",
+		MsgLinkedRestricted: "Аккаунт привязан, но администраторы группы ограничили тебя, попроси их снять ограничение",
+		MsgGraceWarning: "Ты больше не соответствуешь условиям на {{.Provider}}. " +
+			"Если ты отписался или отменил подписку, возобнови её, иначе твои права в группе скоро будут ограничены",
+		MsgAlreadyLinked: "Ты уже привязан к группе",
+		MsgNotLinked:     "Твой аккаунт не привязан",
+		MsgUnlinked:      "Твой аккаунт отвязан",
+		MsgWhiteListed:   "Ты в белом списке!",
+		MsgCooldown:      "Привязка недавно менялась, попробуй снова после {{.Until}}",
+		MsgForgetConfirm: "Все данные о тебе будут удалены (/mydata чтобы их посмотреть), " +
+			"права в группе будут ограничены, пока ты снова не привяжешь аккаунт. Отправь /forgetme confirm чтобы продолжить",
+		MsgForgotten: "Твои данные удалены, права в группе ограничены, пока ты снова не привяжешь аккаунт",
+		MsgError:     "Произошла ошибка! 😟",
+		MsgNotReady:  "Бот ещё не готов",
+
+		MsgPageLinked:        "Авторизация прошла успешно, бот скоро выдаст тебе права",
+		MsgPageRelinked:      "Авторизация прошла успешно, аккаунт перепривязан",
+		MsgPageAlreadyLinked: "Авторизация прошла успешно, аккаунт уже привязан",
+		MsgPageNotEligible:   "Авторизация прошла успешно, но ты не соответствуешь условиям на {{.Provider}}",
+		MsgPageConnected:     "Авторизация прошла успешно, бот подключён к каналу",
+		MsgPageError:         "Что-то пошло не так, попробуй позже",
+		MsgPageTelegramLinked: "Твой telegram аккаунт уже привязан, " +
+			"отправь боту /relink чтобы привязать другой аккаунт {{.Provider}}",
+		MsgPageAccountTaken: "Этот аккаунт {{.Provider}} привязан к другому telegram аккаунту, " +
+			"отправь боту /relink чтобы перенести его сюда",
+		MsgPageCancelled:  "Авторизация на {{.Provider}} отменена, получи новую ссылку через /getlink чтобы попробовать снова",
+		MsgPageBadRequest: "Неверная ссылка, получи новую через /getlink",
+		MsgPageExpired:    "Ссылка устарела, получи новую через /getlink",
+		MsgPageTooMany:    "Слишком много запросов, попробуй через минуту",
+		MsgPageOpenBot:    "Открыть бота",
+		MsgTitleSuccess:   "Готово",
+		MsgTitleDenied:    "Доступ запрещён",
+		MsgTitleError:     "Ошибка",
+	},
+}
+
+// Messages catalogue of message templates by locale, built-in en and ru, texts and locales added by Override.
+// Missing texts of locale taken from default locale
+type Messages struct {
+	locale    string
+	templates map[string]map[Message]*template.Template
+}
+
+// NewMessages built-in catalogue with en default locale
+func NewMessages() *Messages {
+	m := &Messages{
+		locale:    "en",
+		templates: make(map[string]map[Message]*template.Template),
+	}
+
+	for locale, texts := range defaultMessages {
+		for key, text := range texts {
+			if err := m.set(locale, key, text); err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	return m
+}
+
+func (m *Messages) set(locale string, key Message, text string) error {
+	if _, found := defaultMessages["en"][key]; !found {
+		return fmt.Errorf("unknown message %q", key)
+	}
+
+	t, err := template.New(string(key)).Parse(text)
+	if err != nil {
+		return fmt.Errorf("message %s %q: %w", locale, key, err)
+	}
+
+	if m.templates[locale] == nil {
+		m.templates[locale] = make(map[Message]*template.Template)
+	}
+	m.templates[locale][key] = t
+
+	return nil
+}
+
+// Override replace texts by json {"locale": {"message": "text"}}, e.g. {"ru": {"rights_full": "Добро пожаловать!"}}
+func (m *Messages) Override(r io.Reader) error {
+	var texts map[string]map[Message]string
+	if err := json.NewDecoder(r).Decode(&texts); err != nil {
+		return err
+	}
+
+	for locale, localeTexts := range texts {
+		locale = strings.ToLower(locale)
+		for key, text := range localeTexts {
+			if err := m.set(locale, key, text); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// SetDefault locale used for users with unknown or not supported language
+func (m *Messages) SetDefault(locale string) error {
+	locale = strings.ToLower(locale)
+	if _, found := m.templates[locale]; !found {
+		return fmt.Errorf("unknown locale %q, expected one of %v", locale, m.Locales())
+	}
+	m.locale = locale
+
+	return nil
+}
+
+// Locales supported locales, sorted
+func (m *Messages) Locales() []string {
+	rs := make([]string, 0, len(m.templates))
+	for locale := range m.templates {
+		rs = append(rs, locale)
+	}
+	sort.Strings(rs)
+
+	return rs
+}
+
+// Locale first supported of language tags ("ru", "ru-RU"), default locale if none
+func (m *Messages) Locale(tags ...string) string {
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if _, found := m.templates[tag]; found {
+			return tag
+		}
+		if i := strings.IndexAny(tag, "-_"); i > 0 {
+			if _, found := m.templates[tag[:i]]; found {
+				return tag[:i]
+			}
+		}
+	}
+
+	return m.locale
+}
+
+// Text render message in locale
+func (m *Messages) Text(locale string, key Message, args Args) string {
+	t, found := m.templates[locale][key]
+	if !found {
+		t, found = m.templates[m.locale][key]
+	}
+	if !found {
+		t, found = m.templates["en"][key]
+	}
+	if !found {
+		return string(key)
+	}
+
+	rs := &strings.Builder{}
+	if err := t.Execute(rs, args); err != nil {
+		log.Println("ERROR: ", err)
+		return string(key)
+	}
+
+	return rs.String()
+}
+
+// ParseAcceptLanguage language tags of Accept-Language header, by preference
+func ParseAcceptLanguage(header string) []string {
+	type tag struct {
+		name string
+		q    float64
+	}
+
+	var tags []tag
+	for _, item := range strings.Split(header, ",") {
+		parts := strings.Split(item, ";")
+		name := strings.TrimSpace(parts[0])
+		if name == "" || name == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					v = 0
+				}
+				q = v
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+
+		tags = append(tags, tag{name: name, q: q})
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].q > tags[j].q
+	})
+
+	rs := make([]string, 0, len(tags))
+	for _, t := range tags {
+		rs = append(rs, t.name)
+	}
+
+	return rs
+}
+
+// text message in language of telegram client, e.g. response to command
+func (b *Service) text(language string, key Message, args Args) string {
+	return b.cfg.Messages.Text(b.cfg.Messages.Locale(language), key, args)
+}
+
+// userText message in language user last used with bot
+func (b *Service) userText(tgID int, key Message, args Args) string {
+	return b.text(b.language(tgID), key, args)
+}
+
+// language last language of user, empty if unknown
+func (b *Service) language(tgID int) string {
+	key := fmt.Sprintf("lang:%d", tgID)
+	if language, found := b.cache.Get(key); found {
+		return language.(string)
+	}
+
+	language, err := b.db.GetLanguage(tgID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("ERROR: ", err)
+		return ""
+	}
+	b.cache.SetDefault(key, language)
+
+	return language
+}
+
+// rememberLanguage save language of user client, messages sent later (e.g. grace warning) use it
+func (b *Service) rememberLanguage(tgID int, language string) {
+	if language == "" || b.language(tgID) == language {
+		return
+	}
+
+	if err := b.db.SaveLanguage(tgID, language); err != nil {
+		log.Println("ERROR: ", err)
+		return
+	}
+	b.cache.SetDefault(fmt.Sprintf("lang:%d", tgID), language)
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leporel/ttg/storage"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{header: "", want: []string{}},
+		{header: "ru-RU,ru;q=0.9,en-US;q=0.8,en;q=0.7", want: []string{"ru-RU", "ru", "en-US", "en"}},
+		{header: "en;q=0.5, ru", want: []string{"ru", "en"}},
+		{header: "de, *;q=0.1, fr;q=0", want: []string{"de"}},
+	}
+
+	for _, tt := range tests {
+		got := ParseAcceptLanguage(tt.header)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestMessages(t *testing.T) {
+	for key := range defaultMessages["en"] {
+		if _, found := defaultMessages["ru"][key]; !found {
+			t.Errorf("message %q not translated to ru", key)
+		}
+	}
+
+	m := NewMessages()
+
+	tests := []struct {
+		tags []string
+		want string
+	}{
+		{tags: nil, want: "en"},
+		{tags: []string{"ru"}, want: "ru"},
+		{tags: []string{"RU_ru"}, want: "ru"},
+		{tags: []string{"de", "ru-RU", "en"}, want: "ru"},
+		{tags: []string{"uk"}, want: "en"},
+	}
+	for _, tt := range tests {
+		if got := m.Locale(tt.tags...); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.tags, got, tt.want)
+		}
+	}
+
+	if got := m.Text("ru", MsgRightsChanged, Args{"Profile": "text_only"}); got != "Твои права в группе изменены: text_only" {
+		t.Fatalf("wrong text %q", got)
+	}
+
+	// new locale, missing texts from default locale
+	err := m.Override(strings.NewReader(`{"uk": {"rights_full": "Тепер ти можеш писати в групі"}, "ru": {"error": "Ой"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetDefault("ru"); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Text(m.Locale("uk-UA"), MsgRightsFull, nil); got != "Тепер ти можеш писати в групі" {
+		t.Fatalf("wrong override %q", got)
+	}
+	if got := m.Text("uk", MsgNotLinked, nil); got != "Твой аккаунт не привязан" {
+		t.Fatalf("missing text must be taken from default locale, got %q", got)
+	}
+	if got := m.Text("ru", MsgError, nil); got != "Ой" {
+		t.Fatalf("wrong override %q", got)
+	}
+
+	for _, override := range []string{
+		`{"ru": {"unknown": "text"}}`,
+		`{"ru": {"error": "{{.Until"}}`,
+		`["ru"]`,
+	} {
+		if err := m.Override(strings.NewReader(override)); err == nil {
+			t.Errorf("%s: wrong override must fail", override)
+		}
+	}
+	if err := m.SetDefault("de"); err == nil {
+		t.Fatal("unknown default locale must fail")
+	}
+}
+
+func TestService_language(t *testing.T) {
+	app := &mockIdentity{
+		codes:     map[string]string{"code-right": "tok-right"},
+		users:     map[string]*Identity{"tok-right": {ID: "5", Name: "User5"}},
+		followers: map[string]string{"5": "user5"},
+	}
+	bot, chat, store := newMockService(app)
+
+	response, err := bot.Handle(CommandGetLink, Data{UserID: 1, Language: "ru-RU"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(response, "Ссылка: <a href=") {
+		t.Fatalf("link must be in russian html, got %q", response)
+	}
+	if store.languages[1] != "ru-RU" {
+		t.Fatalf("language must be saved, got %q", store.languages[1])
+	}
+
+	// browser language used for page, user language for messages sent later
+	uid, _ := bot.cache.Get("1")
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/auth/callback?state=%s&code=code-right", uid), nil)
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	if err := bot.handleOAuth2Callback(rec, req); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `<html lang="en">`) {
+		t.Fatalf("page must be in english, got %d %s", rec.Code, rec.Body.String())
+	}
+	if got := chat.messages[1]; len(got) != 1 || got[0] != "Теперь ты можешь писать в группе" {
+		t.Fatalf("rights message must be in russian, got %v", got)
+	}
+
+	// not eligible page in browser language
+	app.users["tok-right"] = &Identity{ID: "6", Name: "User6"}
+	response, err = bot.Handle(CommandGetLink, Data{UserID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid, _ = bot.cache.Get("2")
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/auth/callback?state=%s&code=code-right", uid), nil)
+	req.Header.Set("Accept-Language", "ru")
+	if err := bot.handleOAuth2Callback(rec, req); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "ты не соответствуешь условиям на Twitch") {
+		t.Fatalf("page must be in russian, got %d %s", rec.Code, rec.Body.String())
+	}
+	if !strings.HasPrefix(response, "Link: ") {
+		t.Fatalf("user without language must get default locale, got %q", response)
+	}
+
+	// warning in language of user
+	store.users[3] = &storage.User{TelegramID: 3, TwitchID: 7, CreatedAt: time.Now()}
+	if _, err := bot.Handle(CommandSeenUser, Data{UserID: 3, Language: "ru"}); err != nil {
+		t.Fatal(err)
+	}
+	bot.cfg.GraceSweeps = 2
+	if err := bot.CheckPermissions(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := chat.messages[3]; len(got) != 1 || !strings.HasPrefix(got[0], "Ты больше не соответствуешь условиям на Twitch") {
+		t.Fatalf("warning must be in russian, got %v", got)
+	}
+
+	// added by owner, notice in language of user, not of owner
+	if _, err := bot.Handle(CommandAddWhiteList, Data{UserID: 3, Actor: 1000, Language: "en"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := chat.messages[3]; got[len(got)-1] != "Ты в белом списке!" {
+		t.Fatalf("white list notice must be in russian, got %v", got)
+	}
+}
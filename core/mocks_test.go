@@ -0,0 +1,702 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leporel/ttg/storage"
+)
+
+// In-memory mocks of Service dependencies, for unit tests of callback and sweep logic
+
+// mockIdentity identity provider, codes exchanged to tokens of users, followers are entitled
+type mockIdentity struct {
+	// code -> access token
+	codes map[string]string
+	// access token -> user
+	users map[string]*Identity
+	// twitchID -> login of channel followers
+	followers map[string]string
+	// twitchID -> statuses, GetStatuses fail with errStatuses
+	statuses    map[string][]Status
+	errStatuses error
+
+	errToken     error
+	errUser      error
+	errFollows   error
+	errFollowers error
+	errConnect   error
+
+	// onEntitled called on every Entitled, e.g. to check service lock
+	onEntitled func()
+
+	connected *storage.Token
+}
+
+func (m *mockIdentity) GetAuthLink(state string) (string, error) {
+	return "https://id.twitch.tv/oauth2/authorize?state=" + state, nil
+}
+
+func (m *mockIdentity) Name() string {
+	return "Twitch"
+}
+
+func (m *mockIdentity) Authorize(code string) (string, *Identity, error) {
+	if m.errToken != nil {
+		return "", nil, m.errToken
+	}
+	token, found := m.codes[code]
+	if !found {
+		return "", nil, fmt.Errorf("invalid code %s", code)
+	}
+	if m.errUser != nil {
+		return "", nil, m.errUser
+	}
+	user, found := m.users[token]
+	if !found {
+		return "", nil, fmt.Errorf("invalid token %s", token)
+	}
+	return token, user, nil
+}
+
+func (m *mockIdentity) Entitled(_ string, user *Identity) (bool, error) {
+	if m.onEntitled != nil {
+		m.onEntitled()
+	}
+	if m.errFollows != nil {
+		return false, m.errFollows
+	}
+	_, found := m.followers[user.ID]
+	return found, nil
+}
+
+func (m *mockIdentity) GetEntitled(ids []string) (map[string]string, int, error) {
+	if m.errFollowers != nil {
+		return nil, 0, m.errFollowers
+	}
+	rs := make(map[string]string)
+	for _, id := range ids {
+		if login, found := m.followers[id]; found {
+			rs[id] = login
+		}
+	}
+	return rs, len(m.followers), nil
+}
+
+func (m *mockIdentity) GetStatuses(ids []string) (map[string][]Status, error) {
+	if m.errStatuses != nil {
+		return nil, m.errStatuses
+	}
+	rs := make(map[string][]Status)
+	for _, id := range ids {
+		if st, found := m.statuses[id]; found {
+			rs[id] = st
+		}
+	}
+	return rs, nil
+}
+
+func (m *mockIdentity) GetConnectLink(state string) string {
+	return "https://id.twitch.tv/oauth2/authorize?force_verify=true&state=" + state
+}
+
+func (m *mockIdentity) Connect(code string) (*storage.Token, error) {
+	if m.errConnect != nil {
+		return nil, m.errConnect
+	}
+	m.connected = &storage.Token{Name: storage.TokenBroadcaster, Login: "broadcaster", AccessToken: code}
+	return m.connected, nil
+}
+
+func (m *mockIdentity) CapabilitiesReport() string {
+	if m.connected == nil {
+		return "broadcaster not connected"
+	}
+	return "Twitch connected as " + m.connected.Login
+}
+
+func (m *mockIdentity) ValidateTokens() error {
+	return nil
+}
+
+// mockChat record rights and messages instead of telegram requests
+type mockChat struct {
+	mu sync.Mutex
+	// userID -> muted
+	rights   map[int]bool
+	profiles map[int]string
+	messages map[int][]string
+	owner    []string
+
+	// users not in group, SetRights fail for them
+	absent map[int]bool
+	// userID -> role, admins can not be restricted
+	roles map[int]*Role
+	// admins appointed by hand, bot can not change them
+	admins map[int]bool
+	// users restricted by chat admin, and profile bot own passed to last SetRights
+	manual map[int]bool
+	owned  map[int]*Profile
+
+	// deepLink link to bot shown on callback pages, empty for none
+	deepLink string
+}
+
+func newMockChat() *mockChat {
+	return &mockChat{
+		rights:   make(map[int]bool),
+		profiles: make(map[int]string),
+		messages: make(map[int][]string),
+		absent:   make(map[int]bool),
+		roles:    make(map[int]*Role),
+		admins:   make(map[int]bool),
+		manual:   make(map[int]bool),
+		owned:    make(map[int]*Profile),
+	}
+}
+
+func (m *mockChat) Name() string { return "Telegram" }
+
+func (m *mockChat) DeepLink() string { return m.deepLink }
+
+func (m *mockChat) Start(_ Handler) {}
+
+func (m *mockChat) Stop() {}
+
+func (m *mockChat) SetRights(userID int, profile Profile, owned *Profile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// restriction bot does not own is manual, as in telegram
+	if m.manual[userID] || m.rights[userID] && owned == nil {
+		return ErrManualRestriction
+	}
+	m.owned[userID] = owned
+
+	mute := profile.Muted()
+
+	if m.absent[userID] {
+		return ErrNotMember
+	}
+	if mute && (m.roles[userID] != nil || m.admins[userID]) {
+		return ErrChatAdmin
+	}
+	m.rights[userID] = mute
+	m.profiles[userID] = profile.Name
+	return nil
+}
+
+func (m *mockChat) SetRole(userID int, role *Role, owned *Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.absent[userID] {
+		return ErrNotMember
+	}
+	if m.admins[userID] && owned == nil {
+		return ErrChatAdmin
+	}
+	if role == nil {
+		delete(m.roles, userID)
+		return nil
+	}
+	m.roles[userID] = role
+	return nil
+}
+
+// role return title of user role, empty if user is not admin
+func (m *mockChat) role(userID int) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if r := m.roles[userID]; r != nil {
+		return r.Title
+	}
+	return ""
+}
+
+func (m *mockChat) SendUser(userID int, msg interface{}, _ ...interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages[userID] = append(m.messages[userID], fmt.Sprint(msg))
+}
+
+func (m *mockChat) SendOwner(msg interface{}, _ ...interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.owner = append(m.owner, fmt.Sprint(msg))
+}
+
+// muted return user rights state, second value false if rights never changed
+func (m *mockChat) muted(userID int) (bool, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mute, found := m.rights[userID]
+	return mute, found
+}
+
+// mockStore storage in maps, fail make named method return error
+type mockStore struct {
+	whitelist map[int]*storage.WhiteListedUser
+	users     map[int]*storage.User
+	members   map[int]*storage.Member
+	leavers   map[int]time.Time
+	audit     []storage.AuditEntry
+	graces    map[int]*storage.Grace
+	roles     map[int]string
+	profiles  map[int]string
+	cooldowns map[string]time.Time
+	languages map[int]string
+
+	fail map[string]error
+}
+
+func newMockStore() *mockStore {
+	return &mockStore{
+		whitelist: make(map[int]*storage.WhiteListedUser),
+		users:     make(map[int]*storage.User),
+		members:   make(map[int]*storage.Member),
+		leavers:   make(map[int]time.Time),
+		graces:    make(map[int]*storage.Grace),
+		roles:     make(map[int]string),
+		profiles:  make(map[int]string),
+		cooldowns: make(map[string]time.Time),
+		languages: make(map[int]string),
+		fail:      make(map[string]error),
+	}
+}
+
+func (s *mockStore) AddWhiteList(user *storage.WhiteListedUser) error {
+	if err := s.fail["AddWhiteList"]; err != nil {
+		return err
+	}
+	s.whitelist[user.TelegramID] = user
+	return nil
+}
+
+func (s *mockStore) GetWhiteListedUser(tgID int) (*storage.WhiteListedUser, error) {
+	if err := s.fail["GetWhiteListedUser"]; err != nil {
+		return nil, err
+	}
+	user, found := s.whitelist[tgID]
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (s *mockStore) AddUser(user *storage.User) error {
+	if err := s.fail["AddUser"]; err != nil {
+		return err
+	}
+	s.users[user.TelegramID] = user
+	return nil
+}
+
+func (s *mockStore) GetUserByTgId(tgID int) (*storage.User, error) {
+	if err := s.fail["GetUserByTgId"]; err != nil {
+		return nil, err
+	}
+	user, found := s.users[tgID]
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (s *mockStore) GetUserByTwId(twID int) (*storage.User, error) {
+	if err := s.fail["GetUserByTwId"]; err != nil {
+		return nil, err
+	}
+	for _, user := range s.users {
+		if user.TwitchID == twID {
+			return user, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *mockStore) SaveLeaver(tgID int, leftAt time.Time) error {
+	if err := s.fail["SaveLeaver"]; err != nil {
+		return err
+	}
+	s.leavers[tgID] = leftAt
+	return nil
+}
+
+func (s *mockStore) DeleteLeaver(tgID int) error {
+	if err := s.fail["DeleteLeaver"]; err != nil {
+		return err
+	}
+	delete(s.leavers, tgID)
+	return nil
+}
+
+func (s *mockStore) GetLeavers() (map[int]time.Time, error) {
+	if err := s.fail["GetLeavers"]; err != nil {
+		return nil, err
+	}
+	rs := make(map[int]time.Time, len(s.leavers))
+	for id, leftAt := range s.leavers {
+		rs[id] = leftAt
+	}
+	return rs, nil
+}
+
+func (s *mockStore) GetMembers() (map[int]*storage.Member, error) {
+	if err := s.fail["GetMembers"]; err != nil {
+		return nil, err
+	}
+	rs := make(map[int]*storage.Member, len(s.members))
+	for id, member := range s.members {
+		rs[id] = member
+	}
+	return rs, nil
+}
+
+func (s *mockStore) DeleteUser(tgID int) error {
+	if err := s.fail["DeleteUser"]; err != nil {
+		return err
+	}
+	if _, found := s.users[tgID]; !found {
+		return sql.ErrNoRows
+	}
+	delete(s.users, tgID)
+	return nil
+}
+
+func (s *mockStore) RelinkUser(user *storage.User) ([]storage.User, error) {
+	if err := s.fail["RelinkUser"]; err != nil {
+		return nil, err
+	}
+	var replaced []storage.User
+	for tgID, u := range s.users {
+		if u.TelegramID == user.TelegramID || u.TwitchID == user.TwitchID {
+			replaced = append(replaced, *u)
+			delete(s.users, tgID)
+		}
+	}
+	s.users[user.TelegramID] = user
+	return replaced, nil
+}
+
+func (s *mockStore) SaveCooldown(account string, until time.Time) error {
+	if err := s.fail["SaveCooldown"]; err != nil {
+		return err
+	}
+	s.cooldowns[account] = until
+	return nil
+}
+
+func (s *mockStore) GetCooldown(account string) (time.Time, error) {
+	if err := s.fail["GetCooldown"]; err != nil {
+		return time.Time{}, err
+	}
+	until, found := s.cooldowns[account]
+	if !found {
+		return time.Time{}, sql.ErrNoRows
+	}
+	return until, nil
+}
+
+func (s *mockStore) DeleteCooldown(account string) error {
+	if err := s.fail["DeleteCooldown"]; err != nil {
+		return err
+	}
+	delete(s.cooldowns, account)
+	return nil
+}
+
+func (s *mockStore) GetUsers() (map[string]int, error) {
+	if err := s.fail["GetUsers"]; err != nil {
+		return nil, err
+	}
+	rs := make(map[string]int, len(s.users))
+	for _, user := range s.users {
+		rs[fmt.Sprint(user.TwitchID)] = user.TelegramID
+	}
+	return rs, nil
+}
+
+func (s *mockStore) SaveMember(member *storage.Member) error {
+	if err := s.fail["SaveMember"]; err != nil {
+		return err
+	}
+	member.Username = strings.ToLower(member.Username)
+	s.members[member.TelegramID] = member
+	return nil
+}
+
+func (s *mockStore) GetMemberByUsername(username string) (*storage.Member, error) {
+	if err := s.fail["GetMemberByUsername"]; err != nil {
+		return nil, err
+	}
+	for _, member := range s.members {
+		if member.Username == strings.ToLower(username) {
+			return member, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *mockStore) AddAudit(entry *storage.AuditEntry) error {
+	if err := s.fail["AddAudit"]; err != nil {
+		return err
+	}
+	entry.ID = len(s.audit) + 1
+	s.audit = append(s.audit, *entry)
+	return nil
+}
+
+func (s *mockStore) GetAudit(tgID int, limit int) ([]storage.AuditEntry, error) {
+	if err := s.fail["GetAudit"]; err != nil {
+		return nil, err
+	}
+	var rs []storage.AuditEntry
+	for i := len(s.audit) - 1; i >= 0 && (limit <= 0 || len(rs) < limit); i-- {
+		if tgID == 0 || s.audit[i].TelegramID == tgID {
+			rs = append(rs, s.audit[i])
+		}
+	}
+	return rs, nil
+}
+
+func (s *mockStore) SaveGrace(g *storage.Grace) error {
+	if err := s.fail["SaveGrace"]; err != nil {
+		return err
+	}
+	s.graces[g.TelegramID] = g
+	return nil
+}
+
+func (s *mockStore) DeleteGrace(tgID int) error {
+	if err := s.fail["DeleteGrace"]; err != nil {
+		return err
+	}
+	delete(s.graces, tgID)
+	return nil
+}
+
+func (s *mockStore) GetGraces() (map[int]*storage.Grace, error) {
+	if err := s.fail["GetGraces"]; err != nil {
+		return nil, err
+	}
+	rs := make(map[int]*storage.Grace, len(s.graces))
+	for id, g := range s.graces {
+		c := *g
+		rs[id] = &c
+	}
+	return rs, nil
+}
+
+func (s *mockStore) SaveRole(tgID int, status string) error {
+	if err := s.fail["SaveRole"]; err != nil {
+		return err
+	}
+	s.roles[tgID] = status
+	return nil
+}
+
+func (s *mockStore) DeleteRole(tgID int) error {
+	if err := s.fail["DeleteRole"]; err != nil {
+		return err
+	}
+	delete(s.roles, tgID)
+	return nil
+}
+
+func (s *mockStore) GetRoles() (map[int]string, error) {
+	if err := s.fail["GetRoles"]; err != nil {
+		return nil, err
+	}
+	rs := make(map[int]string, len(s.roles))
+	for id, status := range s.roles {
+		rs[id] = status
+	}
+	return rs, nil
+}
+
+func (s *mockStore) SaveProfile(tgID int, name string) error {
+	if err := s.fail["SaveProfile"]; err != nil {
+		return err
+	}
+	s.profiles[tgID] = name
+	return nil
+}
+
+func (s *mockStore) GetProfile(tgID int) (string, error) {
+	if err := s.fail["GetProfile"]; err != nil {
+		return "", err
+	}
+	name, found := s.profiles[tgID]
+	if !found {
+		return "", sql.ErrNoRows
+	}
+	return name, nil
+}
+
+func (s *mockStore) DeleteProfile(tgID int) error {
+	if err := s.fail["DeleteProfile"]; err != nil {
+		return err
+	}
+	delete(s.profiles, tgID)
+	return nil
+}
+
+func (s *mockStore) GetProfiles() (map[int]string, error) {
+	if err := s.fail["GetProfiles"]; err != nil {
+		return nil, err
+	}
+	rs := make(map[int]string, len(s.profiles))
+	for id, name := range s.profiles {
+		rs[id] = name
+	}
+	return rs, nil
+}
+
+func (s *mockStore) SaveLanguage(tgID int, language string) error {
+	if err := s.fail["SaveLanguage"]; err != nil {
+		return err
+	}
+	s.languages[tgID] = language
+	return nil
+}
+
+func (s *mockStore) GetLanguage(tgID int) (string, error) {
+	if err := s.fail["GetLanguage"]; err != nil {
+		return "", err
+	}
+	language, found := s.languages[tgID]
+	if !found {
+		return "", sql.ErrNoRows
+	}
+	return language, nil
+}
+
+func (s *mockStore) ExportUser(tgID int) (*storage.UserData, error) {
+	if err := s.fail["ExportUser"]; err != nil {
+		return nil, err
+	}
+	data := &storage.UserData{
+		TelegramID: tgID,
+		Link:       s.users[tgID],
+		WhiteList:  s.whitelist[tgID],
+		Member:     s.members[tgID],
+		Grace:      s.graces[tgID],
+		Role:       s.roles[tgID],
+		Profile:    s.profiles[tgID],
+		Language:   s.languages[tgID],
+		Cooldowns:  make(map[string]time.Time),
+	}
+	if leftAt, found := s.leavers[tgID]; found {
+		data.LeftAt = &leftAt
+	}
+	if until, found := s.cooldowns[fmt.Sprintf("tg:%d", tgID)]; found {
+		data.Cooldowns[fmt.Sprintf("tg:%d", tgID)] = until
+	}
+	mention := mockMention(tgID)
+	for _, e := range s.audit {
+		switch {
+		case e.TelegramID == tgID:
+			data.Audit = append(data.Audit, e)
+		case e.Actor == tgID || mention.MatchString(e.Reason):
+			data.AuditMentions = append(data.AuditMentions, e)
+		}
+	}
+	return data, nil
+}
+
+// mockMention user id in audit reason
+func mockMention(tgID int) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf(`tg:%d\b`, tgID))
+}
+
+func (s *mockStore) ForgetUser(tgID int) (int, int, error) {
+	if err := s.fail["ForgetUser"]; err != nil {
+		return 0, 0, err
+	}
+	accounts := make(map[int]bool)
+	if u := s.users[tgID]; u != nil {
+		accounts[u.TwitchID] = true
+	}
+	for _, e := range s.audit {
+		if e.TelegramID == tgID && e.TwitchID != 0 {
+			accounts[e.TwitchID] = true
+		}
+	}
+	delete(s.users, tgID)
+	delete(s.whitelist, tgID)
+	delete(s.members, tgID)
+	delete(s.graces, tgID)
+	delete(s.roles, tgID)
+	delete(s.profiles, tgID)
+	delete(s.leavers, tgID)
+	delete(s.languages, tgID)
+
+	var kept []storage.AuditEntry
+	anonymised := 0
+	mention := mockMention(tgID)
+	for _, e := range s.audit {
+		if e.TelegramID == tgID {
+			continue
+		}
+		if e.Actor == tgID || mention.MatchString(e.Reason) {
+			if e.Actor == tgID {
+				e.Actor = storage.ActorDeleted
+			}
+			if accounts[e.TwitchID] {
+				e.TwitchID = 0
+			}
+			e.Reason = mention.ReplaceAllString(e.Reason, "tg:deleted")
+			anonymised++
+		}
+		kept = append(kept, e)
+	}
+	deleted := len(s.audit) - len(kept)
+	s.audit = kept
+	return deleted, anonymised, nil
+}
+
+// actions return audited actions of user in insert order
+func (s *mockStore) actions(tgID int) []storage.AuditAction {
+	var rs []storage.AuditAction
+	for _, e := range s.audit {
+		if e.TelegramID == tgID {
+			rs = append(rs, e.Action)
+		}
+	}
+	return rs
+}
+
+// newMockService bot with mocked dependencies, ready to handle callbacks and sweeps
+func newMockService(app *mockIdentity) (*Service, *mockChat, *mockStore) {
+	chat := newMockChat()
+	store := newMockStore()
+
+	bot := New(Config{
+		GraceSweeps:      1,
+		BreakerThreshold: 0.2,
+		BreakerMin:       3,
+	}, app, chat, store)
+	bot.ready = true
+
+	return bot, chat, store
+}
+
+// sortedKeys helper for stable error messages
+func sortedKeys(m map[int]*storage.User) []int {
+	rs := make([]int, 0, len(m))
+	for id := range m {
+		rs = append(rs, id)
+	}
+	sort.Ints(rs)
+	return rs
+}
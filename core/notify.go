@@ -0,0 +1,146 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type NotifyMode int
+
+const (
+	NotifyOff NotifyMode = iota
+	NotifyEvent
+	NotifyDigest
+)
+
+type NotifyKind int
+
+const (
+	NotifyLink NotifyKind = iota
+	NotifyRevoke
+	NotifyError
+	NotifyAPIFailure
+	// NotifyConflict user restricted by chat admin, bot did not change his rights
+	NotifyConflict
+)
+
+var notifyTitles = map[NotifyKind]string{
+	NotifyLink:       "Linked",
+	NotifyRevoke:     "Revoked",
+	NotifyError:      "Errors",
+	NotifyAPIFailure: "API failures",
+	NotifyConflict:   "Conflicts",
+}
+
+// DefaultDigestInterval used when Config.DigestInterval is not positive
+const DefaultDigestInterval = 24 * time.Hour
+
+// digestMaxLines limit of events listed in one digest, counters are always full
+const digestMaxLines = 30
+
+func ParseNotifyMode(s string) (NotifyMode, error) {
+	switch s {
+	case "off":
+		return NotifyOff, nil
+	case "event":
+		return NotifyEvent, nil
+	case "digest":
+		return NotifyDigest, nil
+	}
+
+	return NotifyOff, fmt.Errorf("unknown notify mode '%s', must be one of: off, event, digest", s)
+}
+
+type notifyEvent struct {
+	kind NotifyKind
+	text string
+	at   time.Time
+}
+
+// notifier collect events for owner and send them immediately or as periodic digest
+type notifier struct {
+	mode NotifyMode
+	send func(msg string)
+
+	mu     sync.Mutex
+	events []notifyEvent
+	counts map[NotifyKind]int
+	since  time.Time
+}
+
+func newNotifier(mode NotifyMode, send func(msg string)) *notifier {
+	return &notifier{
+		mode:   mode,
+		send:   send,
+		counts: make(map[NotifyKind]int),
+		since:  time.Now(),
+	}
+}
+
+func (n *notifier) add(kind NotifyKind, format string, args ...interface{}) {
+	if n == nil || n.mode == NotifyOff {
+		return
+	}
+
+	text := fmt.Sprintf(format, args...)
+
+	if n.mode == NotifyEvent {
+		n.send(fmt.Sprintf("%s: %s", notifyTitles[kind], text))
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.counts[kind]++
+	if len(n.events) < digestMaxLines {
+		n.events = append(n.events, notifyEvent{kind: kind, text: text, at: time.Now()})
+	}
+}
+
+// flush send collected digest and reset it, nothing sent if no events happened
+func (n *notifier) flush() {
+	if n == nil || n.mode != NotifyDigest {
+		return
+	}
+
+	n.mu.Lock()
+	msg := n.digest()
+	n.events = nil
+	n.counts = make(map[NotifyKind]int)
+	n.since = time.Now()
+	n.mu.Unlock()
+
+	if msg != "" {
+		n.send(msg)
+	}
+}
+
+func (n *notifier) digest() string {
+	total := 0
+	for _, c := range n.counts {
+		total += c
+	}
+	if total == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("Digest since %s\n", n.since.Format("2006-01-02 15:04")))
+	for _, kind := range []NotifyKind{NotifyLink, NotifyRevoke, NotifyError, NotifyAPIFailure, NotifyConflict} {
+		sb.WriteString(fmt.Sprintf("%s: %d\n", notifyTitles[kind], n.counts[kind]))
+	}
+
+	sb.WriteString("\n")
+	for _, e := range n.events {
+		sb.WriteString(fmt.Sprintf("%s %s: %s\n", e.at.Format("15:04"), notifyTitles[e.kind], e.text))
+	}
+	if total > len(n.events) {
+		sb.WriteString(fmt.Sprintf("... and %d more\n", total-len(n.events)))
+	}
+
+	return sb.String()
+}
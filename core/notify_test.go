@@ -0,0 +1,67 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNotifierDigest(t *testing.T) {
+	var sent []string
+	n := newNotifier(NotifyDigest, func(msg string) {
+		sent = append(sent, msg)
+	})
+
+	n.flush()
+	if len(sent) != 0 {
+		t.Fatal("empty digest must not be sent")
+	}
+
+	n.add(NotifyLink, "tg:%d", 1)
+	n.add(NotifyRevoke, "tg:%d", 2)
+	n.add(NotifyRevoke, "tg:%d", 3)
+	if len(sent) != 0 {
+		t.Fatal("digest mode must not send events immediately")
+	}
+
+	n.flush()
+	if len(sent) != 1 {
+		t.Fatalf("expected one digest, got %d", len(sent))
+	}
+	if !strings.Contains(sent[0], "Revoked: 2") || !strings.Contains(sent[0], "Linked: 1") {
+		t.Fatalf("wrong digest: %s", sent[0])
+	}
+
+	n.flush()
+	if len(sent) != 1 {
+		t.Fatal("digest must be reset after flush")
+	}
+}
+
+func TestNotifierEvent(t *testing.T) {
+	var sent []string
+	n := newNotifier(NotifyEvent, func(msg string) {
+		sent = append(sent, msg)
+	})
+
+	n.add(NotifyAPIFailure, "get followers: %s", "timeout")
+	if len(sent) != 1 || sent[0] != "API failures: get followers: timeout" {
+		t.Fatalf("wrong event: %v", sent)
+	}
+
+	var nilNotifier *notifier
+	nilNotifier.add(NotifyError, "must not panic")
+	nilNotifier.flush()
+}
+
+func TestNew_digestInterval(t *testing.T) {
+	// embedded service without interval must not panic on start
+	bot := New(Config{Notify: NotifyDigest}, &mockIdentity{}, newMockChat(), newMockStore())
+	if bot.cfg.DigestInterval != DefaultDigestInterval {
+		t.Fatalf("interval %v, want %v", bot.cfg.DigestInterval, DefaultDigestInterval)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	bot.Run(ctx)
+}
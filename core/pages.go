@@ -0,0 +1,124 @@
+package core
+
+import (
+	_ "embed"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+)
+
+//go:embed pages/page.html
+var defaultPage string
+
+// DeepLinker chat platform with link opening chat with bot, e.g. https://t.me/bot,
+// shown on callback pages to return user back to chat
+type DeepLinker interface {
+	DeepLink() string
+}
+
+// Kinds of callback outcome, used by page template for style
+const (
+	PageSuccess = "success"
+	PageDenied  = "denied"
+	PageError   = "error"
+)
+
+// outcome result of callback shown to user
+type outcome struct {
+	name   string
+	kind   string
+	status int
+	text   Message
+}
+
+var (
+	outcomeLinked        = outcome{name: "linked", kind: PageSuccess, status: http.StatusOK, text: MsgPageLinked}
+	outcomeRelinked      = outcome{name: "relinked", kind: PageSuccess, status: http.StatusOK, text: MsgPageRelinked}
+	outcomeAlreadyLinked = outcome{name: "already_linked", kind: PageSuccess, status: http.StatusOK, text: MsgPageAlreadyLinked}
+	outcomeConnected     = outcome{name: "connected", kind: PageSuccess, status: http.StatusOK, text: MsgPageConnected}
+
+	outcomeTelegramLinked = outcome{name: "telegram_linked", kind: PageDenied, status: http.StatusConflict, text: MsgPageTelegramLinked}
+	outcomeAccountTaken   = outcome{name: "account_taken", kind: PageDenied, status: http.StatusConflict, text: MsgPageAccountTaken}
+	outcomeCooldown       = outcome{name: "cooldown", kind: PageDenied, status: http.StatusForbidden, text: MsgCooldown}
+	outcomeNotEligible    = outcome{name: "not_eligible", kind: PageDenied, status: http.StatusForbidden, text: MsgPageNotEligible}
+	outcomeCancelled      = outcome{name: "cancelled", kind: PageDenied, status: http.StatusForbidden, text: MsgPageCancelled}
+
+	outcomeBadRequest = outcome{name: "bad_request", kind: PageError, status: http.StatusBadRequest, text: MsgPageBadRequest}
+	outcomeExpired    = outcome{name: "expired", kind: PageError, status: http.StatusGone, text: MsgPageExpired}
+	outcomeTooMany    = outcome{name: "too_many_requests", kind: PageError, status: http.StatusTooManyRequests, text: MsgPageTooMany}
+	outcomeNotReady   = outcome{name: "not_ready", kind: PageError, status: http.StatusServiceUnavailable, text: MsgNotReady}
+	outcomeError      = outcome{name: "error", kind: PageError, status: http.StatusInternalServerError, text: MsgPageError}
+)
+
+var kindTitles = map[string]Message{
+	PageSuccess: MsgTitleSuccess,
+	PageDenied:  MsgTitleDenied,
+	PageError:   MsgTitleError,
+}
+
+// PageData values of callback page template
+type PageData struct {
+	// Lang locale of page texts
+	Lang string
+	// Outcome e.g. "linked", "not_eligible", "expired"
+	Outcome string
+	// Kind PageSuccess, PageDenied or PageError
+	Kind   string
+	Status int
+	Title  string
+	Text   string
+	// BotLink link back to chat with bot, empty if chat platform has no deep link
+	BotLink string
+	BotText string
+}
+
+// Pages html template of callback pages, embedded default replaced by Override to theme pages
+type Pages struct {
+	t *template.Template
+}
+
+// NewPages embedded default template
+func NewPages() *Pages {
+	return &Pages{t: template.Must(template.New("page").Parse(defaultPage))}
+}
+
+// Override replace template, executed with PageData
+func (p *Pages) Override(r io.Reader) error {
+	text, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	t, err := template.New("page").Parse(string(text))
+	if err != nil {
+		return err
+	}
+	p.t = t
+
+	return nil
+}
+
+// page write callback page of outcome in language of browser
+func (b *Service) page(w http.ResponseWriter, r *http.Request, o outcome, args Args) {
+	locale := b.cfg.Messages.Locale(ParseAcceptLanguage(r.Header.Get("Accept-Language"))...)
+
+	data := PageData{
+		Lang:    locale,
+		Outcome: o.name,
+		Kind:    o.kind,
+		Status:  o.status,
+		Title:   b.cfg.Messages.Text(locale, kindTitles[o.kind], args),
+		Text:    b.cfg.Messages.Text(locale, o.text, args),
+	}
+	if dl, ok := b.chat.(DeepLinker); ok && dl.DeepLink() != "" {
+		data.BotLink = dl.DeepLink()
+		data.BotText = b.cfg.Messages.Text(locale, MsgPageOpenBot, args)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(o.status)
+	if err := b.cfg.Pages.t.Execute(w, data); err != nil {
+		log.Println("ERROR: ", err)
+	}
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestService_page(t *testing.T) {
+	const tgID = 42
+
+	tests := []struct {
+		name     string
+		language string
+		deepLink string
+		error    string
+		prepare  func(store *mockStore)
+
+		wantStatus int
+		want       []string
+		wantNot    []string
+	}{
+		{
+			name:       "linked",
+			deepLink:   "https://t.me/ttg_bot",
+			wantStatus: http.StatusOK,
+			want:       []string{`<html lang="en">`, `<main class="success linked">`, "<title>Done</title>", `href="https://t.me/ttg_bot"`, "Open bot"},
+		},
+		{
+			name:       "linked in russian",
+			language:   "ru-RU,ru;q=0.9",
+			deepLink:   "https://t.me/ttg_bot",
+			wantStatus: http.StatusOK,
+			want:       []string{`<html lang="ru">`, "<h1>Готово</h1>", "Открыть бота"},
+		},
+		{
+			name:       "no deep link",
+			wantStatus: http.StatusOK,
+			want:       []string{`<main class="success linked">`},
+			wantNot:    []string{`class="button"`},
+		},
+		{
+			name:       "cancelled",
+			error:      "access_denied",
+			wantStatus: http.StatusForbidden,
+			want:       []string{`<main class="denied cancelled">`, "Authorization on Twitch cancelled"},
+		},
+		{
+			name: "storage failed",
+			prepare: func(store *mockStore) {
+				store.fail["GetUserByTgId"] = errors.New("database is locked")
+			},
+			wantStatus: http.StatusInternalServerError,
+			want:       []string{`<main class="error error">`, "Something went wrong"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &mockIdentity{
+				codes:     map[string]string{"code-ok": "tok-ok"},
+				users:     map[string]*Identity{"tok-ok": {ID: "5", Name: "User5"}},
+				followers: map[string]string{"5": "user5"},
+			}
+			bot, chat, store := newMockService(app)
+			chat.deepLink = tt.deepLink
+			if tt.prepare != nil {
+				tt.prepare(store)
+			}
+
+			state := uuid.New().String()
+			bot.cache.SetDefault(state, tgID)
+			query := url.Values{"state": {state}, "code": {"code-ok"}}
+			if tt.error != "" {
+				query.Set("error", tt.error)
+			}
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/auth/callback?"+query.Encode(), nil)
+			if tt.language != "" {
+				req.Header.Set("Accept-Language", tt.language)
+			}
+			handler, err := bot.CallbackHandler()
+			if err != nil {
+				t.Fatal(err)
+			}
+			handler.ServeHTTP(rec, req)
+
+			body := rec.Body.String()
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantStatus, body)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(body, s) {
+					t.Errorf("page must contain %q: %s", s, body)
+				}
+			}
+			for _, s := range tt.wantNot {
+				if strings.Contains(body, s) {
+					t.Errorf("page must not contain %q: %s", s, body)
+				}
+			}
+		})
+	}
+}
+
+func TestService_pageTooMany(t *testing.T) {
+	bot, _, _ := newMockService(&mockIdentity{})
+	handler, err := bot.CallbackHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rec *httptest.ResponseRecorder
+	for i := 0; i < 10; i++ {
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/callback?state=state", nil))
+		if rec.Code != http.StatusBadRequest {
+			break
+		}
+	}
+
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), `<main class="error too_many_requests">`) {
+		t.Fatalf("rate limited request must get page, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestPages_Override(t *testing.T) {
+	pages := NewPages()
+	if err := pages.Override(strings.NewReader(`{{.Outcome}} {{.Status}}: {{.Text}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pages.Override(strings.NewReader(`{{.Outcome`)); err == nil {
+		t.Fatal("broken template must fail")
+	}
+
+	bot, _, _ := newMockService(&mockIdentity{})
+	bot.cfg.Pages = pages
+	bot.ready = false
+
+	rec := httptest.NewRecorder()
+	if err := bot.handleOAuth2Callback(rec, httptest.NewRequest(http.MethodGet, "/auth/callback", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rec.Body.String(), "not_ready 503: "+bot.cfg.Messages.Text("en", MsgNotReady, nil); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
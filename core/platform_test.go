@@ -0,0 +1,95 @@
+package core_test
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/leporel/ttg/core"
+	"github.com/leporel/ttg/internal/fake"
+	"github.com/leporel/ttg/storage"
+	"github.com/leporel/ttg/twitch"
+)
+
+// Same twitch eligibility gate chat platform other than telegram
+func TestService_fakeChatPlatform(t *testing.T) {
+	fh := &fake.Helix{
+		BroadcasterID: "100",
+		Channel:       "leporel",
+		Moderator:     "mod-token",
+		Followers:     fake.NewFollowers(10),
+		Users:         map[string]string{},
+		Codes:         map[string]string{"code-7": "7"},
+	}
+	helixSrv := httptest.NewServer(fh.Handler())
+	t.Cleanup(helixSrv.Close)
+
+	app, err := twitch.NewClient(fh.Channel, "client", "secret", "localhost", helixSrv.URL, helixSrv.URL+"/oauth2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetModeratorToken(&storage.Token{AccessToken: fh.Moderator, RefreshToken: "mod-refresh", ExpiresAt: time.Now().Add(time.Hour)})
+
+	db, err := storage.New(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chat := fake.NewChat()
+	svc := core.New(core.Config{GraceSweeps: 1}, app, chat, db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		svc.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	const userID = 77
+	chat.Join(userID)
+
+	rsp, err := chat.Command(core.CommandGetLink, core.Data{UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	match := regexp.MustCompile(`href="([^"]+)"`).FindStringSubmatch(rsp)
+	if match == nil {
+		t.Fatalf("link not found in %q", rsp)
+	}
+	link, err := url.Parse(html.UnescapeString(match[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/auth/callback?state="+link.Query().Get("state")+"&code=code-7", nil)
+	handler, err := svc.CallbackHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback status %d: %s", rec.Code, rec.Body.String())
+	}
+	if chat.Muted(userID) {
+		t.Fatal("follower must be granted")
+	}
+
+	fh.Unfollow("7")
+	if err := svc.CheckPermissions(false); err != nil {
+		t.Fatal(err)
+	}
+	if !chat.Muted(userID) {
+		t.Fatal("unfollowed user must be muted")
+	}
+}
@@ -0,0 +1,296 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/leporel/ttg/storage"
+	"github.com/patrickmn/go-cache"
+)
+
+// Profile chat permissions of user, applied by ChatPlatform.SetRights
+type Profile struct {
+	Name     string
+	Messages bool
+	Media    bool
+	Polls    bool
+	// Other stickers, gifs, games and inline bots
+	Other bool
+	// Previews of links, links itself can not be forbidden
+	Previews bool
+}
+
+var (
+	ProfileReadOnly = Profile{Name: "read_only"}
+	ProfileTextOnly = Profile{Name: "text_only", Messages: true}
+	ProfileNoMedia  = Profile{Name: "no_media", Messages: true, Polls: true, Other: true}
+	ProfileFull     = Profile{Name: "full", Messages: true, Media: true, Polls: true, Other: true, Previews: true}
+)
+
+var profiles = []Profile{ProfileReadOnly, ProfileTextOnly, ProfileNoMedia, ProfileFull}
+
+// Muted user can not write at all
+func (p Profile) Muted() bool {
+	return !p.Messages
+}
+
+func (p Profile) String() string {
+	return p.Name
+}
+
+// StatusProfile profile of entitled user with status
+type StatusProfile struct {
+	Status  Status
+	Profile Profile
+}
+
+// Profiles restriction profile per eligibility level, zero value restrict not eligible users
+// to read only and give entitled users full rights
+type Profiles struct {
+	Restricted Profile
+	Entitled   Profile
+	// Status profiles override Entitled, first matched by user statuses, so listed by priority
+	Status []StatusProfile
+}
+
+func (p Profiles) restricted() Profile {
+	if p.Restricted.Name == "" {
+		return ProfileReadOnly
+	}
+	return p.Restricted
+}
+
+// entitled profile of entitled user with statuses
+func (p Profiles) entitled(userStatuses []Status) Profile {
+	for _, sp := range p.Status {
+		for _, s := range userStatuses {
+			if sp.Status == s {
+				return sp.Profile
+			}
+		}
+	}
+
+	if p.Entitled.Name == "" {
+		return ProfileFull
+	}
+	return p.Entitled
+}
+
+// ParseProfile parse profile name (read_only, text_only, no_media, full)
+// or custom permissions joined by "+", e.g. "messages+polls"
+func ParseProfile(s string) (Profile, error) {
+	s = strings.TrimSpace(s)
+
+	for _, p := range profiles {
+		if p.Name == s {
+			return p, nil
+		}
+	}
+
+	p := Profile{Name: s}
+	for _, perm := range strings.Split(s, "+") {
+		switch strings.TrimSpace(perm) {
+		case "messages":
+			p.Messages = true
+		case "media":
+			p.Media = true
+		case "polls":
+			p.Polls = true
+		case "other":
+			p.Other = true
+		case "previews":
+			p.Previews = true
+		default:
+			return Profile{}, fmt.Errorf("unknown profile %q, expected one of %v or permissions messages+media+polls+other+previews", s, profiles)
+		}
+	}
+	if !p.Messages {
+		return Profile{}, fmt.Errorf("profile %q: permissions require messages, use read_only to mute", s)
+	}
+
+	return p, nil
+}
+
+// ParseStatusProfiles parse status profiles "sub1=full,vip=no_media"
+func ParseStatusProfiles(s string) ([]StatusProfile, error) {
+	var rs []StatusProfile
+
+	if strings.TrimSpace(s) == "" {
+		return rs, nil
+	}
+
+	seen := make(map[Status]bool)
+
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("status profile %q must be status=profile", item)
+		}
+
+		status := Status(strings.TrimSpace(parts[0]))
+		if !validStatus(status) {
+			return nil, fmt.Errorf("unknown status %q, expected one of %v", status, statuses)
+		}
+		if seen[status] {
+			return nil, fmt.Errorf("status %q mapped twice", status)
+		}
+		seen[status] = true
+
+		p, err := ParseProfile(parts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		rs = append(rs, StatusProfile{Status: status, Profile: p})
+	}
+
+	return rs, nil
+}
+
+// entitledProfile profile of entitled user, his statuses requested only if status profiles set
+func (b *Service) entitledProfile(twID int) Profile {
+	if len(b.cfg.Profiles.Status) == 0 {
+		return b.cfg.Profiles.entitled(nil)
+	}
+
+	sp, ok := b.app.(StatusProvider)
+	if !ok {
+		return b.cfg.Profiles.entitled(nil)
+	}
+
+	id := fmt.Sprint(twID)
+	userStatuses, err := sp.GetStatuses([]string{id})
+	if err != nil {
+		log.Println("ERROR: ", err)
+		b.notify.add(NotifyAPIFailure, "get statuses: %v", err)
+	}
+
+	return b.cfg.Profiles.entitled(userStatuses[id])
+}
+
+// reconcileProfiles apply profiles of entitled users by their statuses, users with roles skipped,
+// chat admins are not restricted. entitled is map[userID]tgID
+func (b *Service) reconcileProfiles(entitled map[string]int, userStatuses map[string][]Status) error {
+	current, err := b.db.GetProfiles()
+	if err != nil {
+		return err
+	}
+	roles, err := b.db.GetRoles()
+	if err != nil {
+		return err
+	}
+
+	for id, tgID := range entitled {
+		if _, found := roles[tgID]; found {
+			continue
+		}
+
+		// users linked before profiles had full rights
+		have, found := current[tgID]
+		if !found {
+			have = ProfileFull.Name
+		}
+
+		profile := b.cfg.Profiles.entitled(userStatuses[id])
+		if have == profile.Name {
+			continue
+		}
+
+		applied, err := b.setRights(tgID, 0, profile)
+		if errors.Is(err, ErrNotMember) {
+			continue
+		}
+		if err != nil {
+			log.Println("ERROR: ", err)
+			b.notify.add(NotifyError, "profile tg:%d: %v", tgID, err)
+			continue
+		}
+		if applied {
+			b.audit(storage.AuditGrant, tgID, 0, storage.ActorBot, "profile "+profile.Name)
+		}
+	}
+
+	return nil
+}
+
+// ownedProfile last profile applied to user by bot, nil if unknown.
+// Users restricted before profiles were saved are found by audit of bot restriction
+func (b *Service) ownedProfile(tgID int) (*Profile, error) {
+	name, err := b.db.GetProfile(tgID)
+	if err == sql.ErrNoRows {
+		return b.auditedProfile(tgID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, nil
+	}
+
+	p, err := ParseProfile(name)
+	if err != nil {
+		// profile removed from config, bot still own it
+		return &Profile{Name: name}, nil
+	}
+
+	return &p, nil
+}
+
+// auditedProfile profile of last rights change audited for user, nil if bot never changed his rights
+func (b *Service) auditedProfile(tgID int) (*Profile, error) {
+	entries, err := b.db.GetAudit(tgID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		switch e.Action {
+		case storage.AuditRestrict:
+			return &ProfileReadOnly, nil
+		case storage.AuditGrant:
+			return &ProfileFull, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// setRights apply profile and remember it as owned by bot. Not applied if user restricted by chat admin,
+// conflict audited and reported to owner
+func (b *Service) setRights(tgID, twID int, profile Profile) (bool, error) {
+	owned, err := b.ownedProfile(tgID)
+	if err != nil {
+		return false, err
+	}
+
+	// conflict reported once, until admin lift restriction and profile applied
+	conflict := fmt.Sprintf("conflict:%d:%s", tgID, profile.Name)
+
+	err = b.chat.SetRights(tgID, profile, owned)
+	if errors.Is(err, ErrManualRestriction) {
+		if b.cache.Add(conflict, true, cache.NoExpiration) == nil {
+			log.Printf("User [%d] restricted by admin, profile %s not applied\n", tgID, profile.Name)
+			b.audit(storage.AuditConflict, tgID, twID, storage.ActorBot, "restricted by admin, profile "+profile.Name+" not applied")
+			b.notify.add(NotifyConflict, "tg:%d restricted by admin, profile %s not applied", tgID, profile.Name)
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	b.cache.Delete(conflict)
+
+	switch profile.Name {
+	case ProfileFull.Name:
+		b.chat.SendUser(tgID, b.userText(tgID, MsgRightsFull, nil))
+	case ProfileReadOnly.Name:
+		b.chat.SendUser(tgID, b.userText(tgID, MsgRightsRestricted, nil))
+	default:
+		b.chat.SendUser(tgID, b.userText(tgID, MsgRightsChanged, Args{"Profile": profile.Name}))
+	}
+
+	return true, b.db.SaveProfile(tgID, profile.Name)
+}
@@ -0,0 +1,220 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leporel/ttg/storage"
+)
+
+func TestParseProfile(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Profile
+		wantErr bool
+	}{
+		{in: "read_only", want: ProfileReadOnly},
+		{in: " full ", want: ProfileFull},
+		{in: "messages+polls", want: Profile{Name: "messages+polls", Messages: true, Polls: true}},
+		{in: "media", wantErr: true},
+		{in: "everything", wantErr: true},
+		{in: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseProfile(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: error %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+
+	sp, err := ParseStatusProfiles("sub1=full, vip=no_media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sp) != 2 || sp[0].Profile != ProfileFull || sp[1].Status != StatusVIP {
+		t.Fatalf("wrong status profiles %+v", sp)
+	}
+	if _, err := ParseStatusProfiles("sub1=full,sub1=text_only"); err == nil {
+		t.Fatal("expected error for duplicated status")
+	}
+}
+
+func TestService_profiles(t *testing.T) {
+	app := &mockIdentity{
+		followers: map[string]string{"101": "u101", "102": "u102"},
+		statuses:  map[string][]Status{"102": {StatusSubscriber1}},
+	}
+	bot, chat, store := newMockService(app)
+	bot.cfg.Profiles = Profiles{
+		Entitled: ProfileTextOnly,
+		Status:   []StatusProfile{{Status: StatusSubscriber1, Profile: ProfileFull}},
+	}
+
+	// followers talk, subscribers post media
+	if err := bot.addUser(1, 101, "u101"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bot.addUser(2, 102, "u102"); err != nil {
+		t.Fatal(err)
+	}
+	if chat.profiles[1] != "text_only" || chat.profiles[2] != "full" {
+		t.Fatalf("wrong profiles on link %v", chat.profiles)
+	}
+
+	// user 1 subscribed, user 2 subscription ended
+	app.statuses = map[string][]Status{"101": {StatusSubscriber1}}
+	if err := bot.CheckPermissions(false); err != nil {
+		t.Fatal(err)
+	}
+	if chat.profiles[1] != "full" || chat.profiles[2] != "text_only" {
+		t.Fatalf("wrong profiles after sweep %v", chat.profiles)
+	}
+	if store.profiles[1] != "full" || store.profiles[2] != "text_only" {
+		t.Fatalf("wrong stored profiles %v", store.profiles)
+	}
+
+	// new member and relinked user
+	if _, err := bot.Handle(CommandRestrict, Data{UserID: 3, Reason: "joined group"}); err != nil {
+		t.Fatal(err)
+	}
+	if chat.profiles[3] != "read_only" {
+		t.Fatalf("new member must be read only, got %q", chat.profiles[3])
+	}
+	store.users[3] = &storage.User{TelegramID: 3, TwitchID: 103, CreatedAt: time.Now()}
+	if _, err := bot.Handle(CommandRegrant, Data{UserID: 3, Actor: 3, Reason: "already linked"}); err != nil {
+		t.Fatal(err)
+	}
+	if chat.profiles[3] != "text_only" {
+		t.Fatalf("linked user must get entitled profile, got %q", chat.profiles[3])
+	}
+
+	// revoked user restricted, restriction owned by bot
+	if err := bot.removeUser(1, 101, storage.ActorBot, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if chat.profiles[1] != "read_only" || store.profiles[1] != "read_only" {
+		t.Fatalf("revoked user must be read only, got %q", chat.profiles[1])
+	}
+
+	// already removed by concurrent sweep or leaver expiry, nothing to do twice
+	audit := len(store.actions(1))
+	if err := bot.removeUser(1, 101, storage.ActorBot, "test"); err != nil {
+		t.Fatalf("removed user must be skipped, got %v", err)
+	}
+	if got := len(store.actions(1)); got != audit {
+		t.Fatalf("removed user must not be audited twice, got %d entries, want %d", got, audit)
+	}
+}
+
+func TestService_manualRestriction(t *testing.T) {
+	app := &mockIdentity{
+		followers: map[string]string{"101": "u101", "102": "u102"},
+		statuses:  map[string][]Status{"102": {StatusSubscriber1}},
+	}
+	bot, chat, store := newMockService(app)
+	bot.cfg.Profiles = Profiles{
+		Entitled: ProfileTextOnly,
+		Status:   []StatusProfile{{Status: StatusSubscriber1, Profile: ProfileFull}},
+	}
+	bot.notify = newNotifier(NotifyEvent, func(msg string) {
+		chat.SendOwner(msg)
+	})
+
+	// muted by admin before link, linked but rights not lifted
+	chat.manual[1] = true
+	if err := bot.addUser(1, 101, "u101"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetUserByTgId(1); err != nil {
+		t.Fatal("restricted user must stay linked")
+	}
+	if _, found := chat.profiles[1]; found {
+		t.Fatal("admin restriction must not be lifted")
+	}
+	if len(store.audit) == 0 || store.audit[len(store.audit)-1].Action != storage.AuditConflict {
+		t.Fatalf("conflict must be audited, got %+v", store.audit)
+	}
+
+	// bot own rights of linked user, then admin mute him and status changed
+	if err := bot.addUser(2, 102, "u102"); err != nil {
+		t.Fatal(err)
+	}
+	if chat.profiles[2] != "full" || chat.owned[2] != nil {
+		t.Fatalf("wrong profile on link %q, owned %v", chat.profiles[2], chat.owned[2])
+	}
+	chat.manual[2] = true
+	app.statuses = nil
+	if err := bot.CheckPermissions(false); err != nil {
+		t.Fatal(err)
+	}
+	if chat.profiles[2] != "full" || store.profiles[2] != "full" {
+		t.Fatalf("admin restriction must not be changed, got %q", chat.profiles[2])
+	}
+
+	// admin lifted restriction, next sweep apply profile owned by bot
+	chat.manual[2] = false
+	if err := bot.CheckPermissions(false); err != nil {
+		t.Fatal(err)
+	}
+	if chat.profiles[2] != "text_only" || chat.owned[2] == nil || chat.owned[2].Name != "full" {
+		t.Fatalf("profile must be applied after admin lift restriction, got %q", chat.profiles[2])
+	}
+
+	conflicts := 0
+	for _, msg := range chat.owner {
+		if strings.HasPrefix(msg, "Conflicts") {
+			conflicts++
+		}
+	}
+	if conflicts != 2 {
+		t.Fatalf("expected 2 conflicts reported, got %v", chat.owner)
+	}
+}
+
+func TestService_ownedProfile(t *testing.T) {
+	tests := []struct {
+		name string
+		// profile saved by bot, nil if never saved
+		profile *string
+		audit   []storage.AuditAction
+		want    string
+	}{
+		{name: "saved profile", profile: strPtr("text_only"), audit: []storage.AuditAction{storage.AuditRestrict}, want: "text_only"},
+		{name: "demoted, rights unknown", profile: strPtr(""), audit: []storage.AuditAction{storage.AuditRestrict}, want: ""},
+		{name: "never changed by bot", want: ""},
+		{name: "legacy restriction", audit: []storage.AuditAction{storage.AuditGrant, storage.AuditRestrict, storage.AuditWarn}, want: "read_only"},
+		{name: "legacy grant", audit: []storage.AuditAction{storage.AuditRestrict, storage.AuditGrant}, want: "full"},
+	}
+
+	for _, tt := range tests {
+		bot, _, store := newMockService(&mockIdentity{})
+		if tt.profile != nil {
+			store.profiles[1] = *tt.profile
+		}
+		for _, action := range tt.audit {
+			store.audit = append(store.audit, storage.AuditEntry{Action: action, TelegramID: 1})
+		}
+
+		owned, err := bot.ownedProfile(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if owned != nil {
+			got = owned.Name
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,206 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/leporel/ttg/storage"
+)
+
+// CooldownError link of account changed recently, account can't be linked again until Until
+type CooldownError struct {
+	Until time.Time
+}
+
+func (e *CooldownError) Error() string {
+	return fmt.Sprintf("Link changed recently, try again after %s", e.Until.Format(time.RFC822))
+}
+
+// relinkState cached state of relink oauth flow started by user
+type relinkState struct {
+	TelegramID int
+}
+
+func tgAccount(tgID int) string {
+	return fmt.Sprintf("tg:%d", tgID)
+}
+
+func twAccount(twID int) string {
+	return fmt.Sprintf("tw:%d", twID)
+}
+
+// cooldown return CooldownError if account can't be linked yet
+func (b *Service) cooldown(account string) error {
+	until, err := b.db.GetCooldown(account)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if time.Now().Before(until) {
+		return &CooldownError{Until: until}
+	}
+
+	return nil
+}
+
+// startCooldown forbid link of accounts for cfg.RelinkCooldown
+func (b *Service) startCooldown(accounts ...string) error {
+	if b.cfg.RelinkCooldown <= 0 {
+		return nil
+	}
+
+	until := time.Now().Add(b.cfg.RelinkCooldown)
+	for _, account := range accounts {
+		if err := b.db.SaveCooldown(account, until); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// liftCooldown allow link of accounts now
+func (b *Service) liftCooldown(accounts ...string) error {
+	for _, account := range accounts {
+		if err := b.db.DeleteCooldown(account); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unlink user by himself, accounts can't be linked again until cooldown end.
+// Owner unlink user without cooldown and lift previous one
+func (b *Service) unlink(tgID, actor int) (string, error) {
+	override := actor != tgID
+
+	user, err := b.db.GetUserByTgId(tgID)
+	if err == sql.ErrNoRows {
+		if !override {
+			return b.userText(tgID, MsgNotLinked, nil), nil
+		}
+		if err := b.liftCooldown(tgAccount(tgID)); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("User %d not linked, link cooldown lifted", tgID), nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if override {
+		if err := b.removeUser(tgID, user.TwitchID, actor, "unlinked by owner"); err != nil {
+			return "", err
+		}
+		if err := b.liftCooldown(tgAccount(tgID), twAccount(user.TwitchID)); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("User %d unlinked, link cooldown lifted", tgID), nil
+	}
+
+	if err := b.removeUser(tgID, user.TwitchID, actor, "unlinked by user"); err != nil {
+		return "", err
+	}
+	if err := b.startCooldown(tgAccount(tgID), twAccount(user.TwitchID)); err != nil {
+		return "", err
+	}
+
+	return b.userText(tgID, MsgUnlinked, nil), nil
+}
+
+// handleRelink link user to authorized account, his previous account and other telegram account
+// linked to authorized one are unlinked in one transaction
+func (b *Service) handleRelink(w http.ResponseWriter, r *http.Request, rs relinkState) error {
+	accessToken, user, err := b.app.Authorize(r.FormValue("code"))
+	if err != nil {
+		return err
+	}
+
+	twID, err := strconv.Atoi(user.ID)
+	if err != nil {
+		return err
+	}
+
+	entitled, err := b.app.Entitled(accessToken, user)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current, err := b.db.GetUserByTgId(rs.TelegramID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if current != nil && current.TwitchID == twID {
+		b.page(w, r, outcomeAlreadyLinked, nil)
+		return nil
+	}
+
+	if err := b.cooldown(twAccount(twID)); err != nil {
+		var ce *CooldownError
+		if !errors.As(err, &ce) {
+			return err
+		}
+		b.page(w, r, outcomeCooldown, Args{"Until": ce.Until.Format(time.RFC822)})
+		return nil
+	}
+
+	if !entitled {
+		b.page(w, r, outcomeNotEligible, Args{"Provider": b.app.Name()})
+		return nil
+	}
+
+	log.Printf("Relink user [%d] to [%s]\n", rs.TelegramID, user.Name)
+
+	replaced, err := b.db.RelinkUser(&storage.User{
+		TelegramID: rs.TelegramID,
+		TwitchID:   twID,
+		Name:       user.Name,
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	accounts := []string{tgAccount(rs.TelegramID)}
+	for _, u := range replaced {
+		if u.TwitchID != twID {
+			accounts = append(accounts, twAccount(u.TwitchID))
+		}
+		if u.TelegramID == rs.TelegramID {
+			b.audit(storage.AuditUnlink, u.TelegramID, u.TwitchID, rs.TelegramID, "relinked")
+			continue
+		}
+
+		// account moved from other telegram account
+		accounts = append(accounts, tgAccount(u.TelegramID))
+		if err := b.unlinked(u.TelegramID, u.TwitchID, rs.TelegramID, fmt.Sprintf("relinked to tg:%d", rs.TelegramID)); err != nil {
+			log.Println("ERROR: ", err)
+			b.notify.add(NotifyError, "unlink tg:%d: %v", u.TelegramID, err)
+		}
+	}
+
+	if err := b.db.DeleteGrace(rs.TelegramID); err != nil {
+		return err
+	}
+	if err := b.startCooldown(accounts...); err != nil {
+		return err
+	}
+	if err := b.linked(rs.TelegramID, twID, user.Name); err != nil {
+		return err
+	}
+
+	b.page(w, r, outcomeRelinked, nil)
+
+	return nil
+}
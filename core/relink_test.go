@@ -0,0 +1,238 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leporel/ttg/storage"
+)
+
+func TestService_unlink(t *testing.T) {
+	app := &mockIdentity{}
+	bot, chat, store := newMockService(app)
+	bot.cfg.RelinkCooldown = time.Hour
+	store.users[1] = &storage.User{TelegramID: 1, TwitchID: 101, CreatedAt: time.Now()}
+	store.users[2] = &storage.User{TelegramID: 2, TwitchID: 102, CreatedAt: time.Now()}
+
+	// user unlink himself, can't link again until cooldown end
+	response, err := bot.Handle(CommandUnlink, Data{UserID: 1, Actor: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response != "Your account unlinked" {
+		t.Fatalf("wrong response %q", response)
+	}
+	if _, found := store.users[1]; found {
+		t.Fatal("user must be unlinked")
+	}
+	if mute, _ := chat.muted(1); !mute {
+		t.Fatal("unlinked user must be restricted")
+	}
+	var ce *CooldownError
+	if _, err := bot.Handle(CommandGetLink, Data{UserID: 1}); !errors.As(err, &ce) {
+		t.Fatalf("link on cooldown must fail, got %v", err)
+	}
+	if _, err := bot.Handle(CommandRelink, Data{UserID: 1}); !errors.As(err, &ce) {
+		t.Fatalf("relink on cooldown must fail, got %v", err)
+	}
+	if _, found := store.cooldowns["tw:101"]; !found {
+		t.Fatal("unlinked twitch account must be on cooldown")
+	}
+
+	// owner lift cooldown of not linked user
+	if _, err := bot.Handle(CommandUnlink, Data{UserID: 1, Actor: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bot.Handle(CommandGetLink, Data{UserID: 1}); err != nil {
+		t.Fatalf("link must be allowed after owner override, got %v", err)
+	}
+
+	// owner unlink user without cooldown
+	response, err = bot.Handle(CommandUnlink, Data{UserID: 2, Actor: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(response, "unlinked") {
+		t.Fatalf("wrong response %q", response)
+	}
+	if _, found := store.cooldowns["tg:2"]; found {
+		t.Fatal("owner unlink must not start cooldown")
+	}
+
+	if response, _ := bot.Handle(CommandUnlink, Data{UserID: 3, Actor: 3}); response != "You are not linked" {
+		t.Fatalf("wrong response %q", response)
+	}
+}
+
+func TestService_handleRelink(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    string
+		prepare func(store *mockStore)
+
+		wantStatus int
+		// tgID -> twID of links after relink
+		wantUsers map[int]int
+		// telegram accounts restricted after relink
+		wantMuted []int
+	}{
+		{
+			name:       "wrong twitch account replaced",
+			code:       "code-right",
+			prepare:    func(store *mockStore) { store.users[1] = &storage.User{TelegramID: 1, TwitchID: 5} },
+			wantStatus: http.StatusOK,
+			wantUsers:  map[int]int{1: 6},
+		},
+		{
+			name:       "twitch account moved from old telegram account",
+			code:       "code-right",
+			prepare:    func(store *mockStore) { store.users[2] = &storage.User{TelegramID: 2, TwitchID: 6} },
+			wantStatus: http.StatusOK,
+			wantUsers:  map[int]int{1: 6},
+			wantMuted:  []int{2},
+		},
+		{
+			name: "both links replaced",
+			code: "code-right",
+			prepare: func(store *mockStore) {
+				store.users[1] = &storage.User{TelegramID: 1, TwitchID: 5}
+				store.users[2] = &storage.User{TelegramID: 2, TwitchID: 6}
+			},
+			wantStatus: http.StatusOK,
+			wantUsers:  map[int]int{1: 6},
+			wantMuted:  []int{2},
+		},
+		{
+			name:       "same account",
+			code:       "code-right",
+			prepare:    func(store *mockStore) { store.users[1] = &storage.User{TelegramID: 1, TwitchID: 6} },
+			wantStatus: http.StatusOK,
+			wantUsers:  map[int]int{1: 6},
+		},
+		{
+			name: "twitch account on cooldown",
+			code: "code-right",
+			prepare: func(store *mockStore) {
+				store.users[1] = &storage.User{TelegramID: 1, TwitchID: 5}
+				store.cooldowns["tw:6"] = time.Now().Add(time.Hour)
+			},
+			wantStatus: http.StatusForbidden,
+			wantUsers:  map[int]int{1: 5},
+		},
+		{
+			name:       "not eligible",
+			code:       "code-stranger",
+			prepare:    func(store *mockStore) { store.users[1] = &storage.User{TelegramID: 1, TwitchID: 5} },
+			wantStatus: http.StatusForbidden,
+			wantUsers:  map[int]int{1: 5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &mockIdentity{
+				codes: map[string]string{"code-right": "tok-right", "code-stranger": "tok-stranger"},
+				users: map[string]*Identity{
+					"tok-right":    {ID: "6", Name: "User6"},
+					"tok-stranger": {ID: "9", Name: "User9"},
+				},
+				followers: map[string]string{"5": "user5", "6": "user6"},
+			}
+			bot, chat, store := newMockService(app)
+			bot.cfg.RelinkCooldown = time.Hour
+			tt.prepare(store)
+
+			response, err := bot.Handle(CommandRelink, Data{UserID: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			state := regexp.MustCompile(`state=([\w-]+)`).FindStringSubmatch(response)[1]
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/auth/callback?state="+state+"&code="+tt.code, nil)
+			if err := bot.handleOAuth2Callback(rec, req); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+
+			got := make(map[int]int)
+			for tgID, u := range store.users {
+				got[tgID] = u.TwitchID
+			}
+			if len(got) != len(tt.wantUsers) {
+				t.Fatalf("users %v, want %v", got, tt.wantUsers)
+			}
+			for tgID, twID := range tt.wantUsers {
+				if got[tgID] != twID {
+					t.Fatalf("users %v, want %v", got, tt.wantUsers)
+				}
+			}
+			for _, tgID := range tt.wantMuted {
+				if mute, _ := chat.muted(tgID); !mute {
+					t.Fatalf("tg:%d must be restricted", tgID)
+				}
+			}
+
+			if tt.wantStatus == http.StatusOK && tt.name != "same account" {
+				if mute, found := chat.muted(1); !found || mute {
+					t.Fatal("relinked user must be allowed to send messages")
+				}
+				if _, found := store.cooldowns["tg:1"]; !found {
+					t.Fatal("relinked user must be on cooldown")
+				}
+			}
+		})
+	}
+}
+
+func TestService_entitledUnlocked(t *testing.T) {
+	for name, command := range map[string]Command{"/getlink": CommandGetLink, "/relink": CommandRelink} {
+		app := &mockIdentity{
+			codes:     map[string]string{"code-ok": "tok-ok"},
+			users:     map[string]*Identity{"tok-ok": {ID: "5", Name: "User5"}},
+			followers: map[string]string{"5": "user5"},
+		}
+		bot, _, _ := newMockService(app)
+
+		// provider api called without service lock, sweeps and other callbacks not blocked by it
+		locked := true
+		app.onEntitled = func() {
+			done := make(chan struct{})
+			go func() {
+				bot.mu.Lock()
+				bot.mu.Unlock()
+				close(done)
+			}()
+			select {
+			case <-done:
+				locked = false
+			case <-time.After(time.Second):
+			}
+		}
+
+		response, err := bot.Handle(command, Data{UserID: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := regexp.MustCompile(`state=([\w-]+)`).FindStringSubmatch(response)[1]
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/auth/callback?state="+state+"&code=code-ok", nil)
+		if err := bot.handleOAuth2Callback(rec, req); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", name, rec.Code, rec.Body.String())
+		}
+		if locked {
+			t.Fatalf("%s: service locked during provider call", name)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/leporel/ttg/storage"
+)
+
+// Status of user in identity provider (subscriber tier, VIP, moderator), mapped to chat role
+type Status string
+
+const (
+	StatusSubscriber1 Status = "sub1"
+	StatusSubscriber2 Status = "sub2"
+	StatusSubscriber3 Status = "sub3"
+	StatusVIP         Status = "vip"
+	StatusModerator   Status = "moderator"
+	// StatusFounder one of first subscribers of channel
+	StatusFounder Status = "founder"
+)
+
+var statuses = []Status{StatusSubscriber1, StatusSubscriber2, StatusSubscriber3, StatusVIP, StatusModerator, StatusFounder}
+
+// Role given in chat to user with Status, e.g. telegram admin with custom title
+type Role struct {
+	Status Status
+	Title  string
+	// Rights names of platform admin rights, e.g. telegram "delete_messages", empty for title only
+	Rights []string
+}
+
+func (r *Role) String() string {
+	if len(r.Rights) == 0 {
+		return fmt.Sprintf("%s=%s", r.Status, r.Title)
+	}
+	return fmt.Sprintf("%s=%s:%s", r.Status, r.Title, strings.Join(r.Rights, "+"))
+}
+
+// StatusProvider identity provider which report user statuses, roles reconciled only if provider implement it
+type StatusProvider interface {
+	// GetStatuses return map[userID]statuses of users among ids, users without status omitted
+	GetStatuses(ids []string) (map[string][]Status, error)
+}
+
+// RoleSetter chat platform able to give roles, roles reconciled only if platform implement it
+type RoleSetter interface {
+	// SetRole promote user to role, nil role demote user back to member.
+	// owned is role bot gave user before, nil if bot did not promote him: admins appointed by hand
+	// must not be changed, ErrChatAdmin returned instead
+	SetRole(userID int, role *Role, owned *Role) error
+}
+
+// ParseRoles parse roles mapping "moderator=Mod:delete_messages+pin_messages,vip=VIP,sub3=Tier 3",
+// first role matched by user statuses is given, so roles listed by priority
+func ParseRoles(s string) ([]Role, error) {
+	var rs []Role
+
+	if strings.TrimSpace(s) == "" {
+		return rs, nil
+	}
+
+	seen := make(map[Status]bool)
+
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("role %q must be status=title[:right+right]", item)
+		}
+
+		status := Status(strings.TrimSpace(parts[0]))
+		if !validStatus(status) {
+			return nil, fmt.Errorf("unknown status %q, expected one of %v", status, statuses)
+		}
+		if seen[status] {
+			return nil, fmt.Errorf("status %q mapped twice", status)
+		}
+		seen[status] = true
+
+		role := Role{Status: status}
+
+		title := parts[1]
+		if i := strings.Index(title, ":"); i >= 0 {
+			for _, right := range strings.Split(title[i+1:], "+") {
+				if right = strings.TrimSpace(right); right != "" {
+					role.Rights = append(role.Rights, right)
+				}
+			}
+			title = title[:i]
+		}
+		role.Title = strings.TrimSpace(title)
+
+		rs = append(rs, role)
+	}
+
+	return rs, nil
+}
+
+func validStatus(status Status) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// roleFor first role of mapping matched by user statuses, nil if none
+func roleFor(roles []Role, userStatuses []Status) *Role {
+	for i := range roles {
+		for _, s := range userStatuses {
+			if roles[i].Status == s {
+				return &roles[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+// rolesEnabled roles mapping configured and supported by provider and chat
+func (b *Service) rolesEnabled() (StatusProvider, RoleSetter, bool) {
+	if len(b.cfg.Roles) == 0 {
+		return nil, nil, false
+	}
+
+	sp, ok := b.app.(StatusProvider)
+	if !ok {
+		return nil, nil, false
+	}
+	rs, ok := b.chat.(RoleSetter)
+	if !ok {
+		return nil, nil, false
+	}
+
+	return sp, rs, true
+}
+
+// reconcile roles and profiles of entitled users by their statuses, entitled is map[userID]tgID.
+// Nothing changed if statuses unavailable, to not demote everyone on api failure
+func (b *Service) reconcile(entitled map[string]int) {
+	var userStatuses map[string][]Status
+
+	if sp, ok := b.app.(StatusProvider); ok && (len(b.cfg.Roles) > 0 || len(b.cfg.Profiles.Status) > 0) {
+		ids := make([]string, 0, len(entitled))
+		for id := range entitled {
+			ids = append(ids, id)
+		}
+
+		var err error
+		userStatuses, err = sp.GetStatuses(ids)
+		if err != nil {
+			log.Println("ERROR: ", err)
+			b.notify.add(NotifyAPIFailure, "get statuses: %v", err)
+			return
+		}
+	}
+
+	if err := b.reconcileRoles(entitled, userStatuses); err != nil {
+		log.Println("ERROR: ", err)
+	}
+	if err := b.reconcileProfiles(entitled, userStatuses); err != nil {
+		log.Println("ERROR: ", err)
+	}
+}
+
+// reconcileRoles give entitled users roles by their statuses, demote users who lost status
+func (b *Service) reconcileRoles(entitled map[string]int, userStatuses map[string][]Status) error {
+	_, setter, ok := b.rolesEnabled()
+	if !ok {
+		return nil
+	}
+
+	current, err := b.db.GetRoles()
+	if err != nil {
+		return err
+	}
+
+	for id, tgID := range entitled {
+		role := roleFor(b.cfg.Roles, userStatuses[id])
+
+		have, found := current[tgID]
+		switch {
+		case role == nil && !found:
+			continue
+		case role != nil && found && have == string(role.Status):
+			continue
+		}
+
+		err := b.setRole(setter, tgID, role, b.ownedRole(have, found))
+		if errors.Is(err, ErrNotMember) {
+			continue
+		}
+		if errors.Is(err, ErrChatAdmin) {
+			log.Printf("Role of user [%d] not changed, admin appointed by hand\n", tgID)
+			continue
+		}
+		if err != nil {
+			log.Println("ERROR: ", err)
+			b.notify.add(NotifyError, "role tg:%d: %v", tgID, err)
+		}
+	}
+
+	return nil
+}
+
+// ownedRole role bot gave user, by status saved on promotion, nil if bot did not promote him
+func (b *Service) ownedRole(status string, found bool) *Role {
+	if !found {
+		return nil
+	}
+	for i := range b.cfg.Roles {
+		if string(b.cfg.Roles[i].Status) == status {
+			return &b.cfg.Roles[i]
+		}
+	}
+
+	// status removed from mapping since promotion
+	return &Role{Status: Status(status)}
+}
+
+// setRole promote user or demote if role nil
+func (b *Service) setRole(setter RoleSetter, tgID int, role *Role, owned *Role) error {
+	if err := setter.SetRole(tgID, role, owned); err != nil {
+		return err
+	}
+
+	if role == nil {
+		if err := b.db.DeleteRole(tgID); err != nil {
+			return err
+		}
+		b.audit(storage.AuditRole, tgID, 0, storage.ActorBot, "demoted")
+
+		// demoted user rights are unknown, profile applied again by reconcileProfiles
+		return b.db.SaveProfile(tgID, "")
+	}
+
+	if err := b.db.SaveRole(tgID, string(role.Status)); err != nil {
+		return err
+	}
+	b.audit(storage.AuditRole, tgID, 0, storage.ActorBot, role.String())
+	if role.Title != "" {
+		b.chat.SendUser(tgID, b.userText(tgID, MsgRole, Args{"Role": role.Title}))
+	}
+
+	return nil
+}
+
+// demote remove role before user restricted, chat can not restrict admins
+func (b *Service) demote(tgID int) error {
+	_, setter, ok := b.rolesEnabled()
+	if !ok {
+		return nil
+	}
+
+	roles, err := b.db.GetRoles()
+	if err != nil {
+		return err
+	}
+	status, found := roles[tgID]
+	if !found {
+		return nil
+	}
+
+	// chat admins lose rights when leave chat, admin appointed by hand since promotion is not bot's anymore
+	err = setter.SetRole(tgID, nil, b.ownedRole(status, found))
+	if err != nil && !errors.Is(err, ErrNotMember) && !errors.Is(err, ErrChatAdmin) {
+		return err
+	}
+	if err := b.db.DeleteRole(tgID); err != nil {
+		return err
+	}
+	b.audit(storage.AuditRole, tgID, 0, storage.ActorBot, "demoted")
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/leporel/ttg/storage"
+)
+
+func TestParseRoles(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []Role
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "vip=VIP", want: []Role{{Status: StatusVIP, Title: "VIP"}}},
+		{
+			in: "moderator=Mod:delete_messages+pin_messages, sub3=Tier 3",
+			want: []Role{
+				{Status: StatusModerator, Title: "Mod", Rights: []string{"delete_messages", "pin_messages"}},
+				{Status: StatusSubscriber3, Title: "Tier 3"},
+			},
+		},
+		{in: "founder=Founder", want: []Role{{Status: StatusFounder, Title: "Founder"}}},
+		{in: "admin=Boss", wantErr: true},
+		{in: "vip", wantErr: true},
+		{in: "vip=VIP,vip=V", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRoles(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: error %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestService_reconcileRoles(t *testing.T) {
+	app := &mockIdentity{
+		followers: map[string]string{"101": "u101", "102": "u102", "103": "u103", "104": "u104", "106": "u106"},
+		statuses: map[string][]Status{
+			"101": {StatusModerator, StatusSubscriber1},
+			"102": {StatusSubscriber1, StatusVIP},
+			"105": {StatusModerator},
+			"106": {StatusModerator},
+		},
+	}
+	bot, chat, store := newMockService(app)
+	bot.cfg.Roles = []Role{
+		{Status: StatusModerator, Title: "Mod", Rights: []string{"delete_messages"}},
+		{Status: StatusVIP, Title: "VIP"},
+		{Status: StatusSubscriber1, Title: "Sub"},
+	}
+
+	for tgID := 1; tgID <= 6; tgID++ {
+		store.users[tgID] = &storage.User{TelegramID: tgID, TwitchID: 100 + tgID, CreatedAt: time.Now()}
+	}
+	// 4 lost vip, 5 unfollowed moderator
+	store.roles[4] = string(StatusVIP)
+	chat.roles[4] = &bot.cfg.Roles[1]
+	store.roles[5] = string(StatusModerator)
+	chat.roles[5] = &bot.cfg.Roles[0]
+	// 6 appointed admin by hand
+	chat.admins[6] = true
+
+	if err := bot.CheckPermissions(false); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[int]string{1: "Mod", 2: "VIP", 3: "", 4: "", 5: "", 6: ""}
+	for tgID, title := range want {
+		if got := chat.role(tgID); got != title {
+			t.Errorf("tg %d role %q, want %q", tgID, got, title)
+		}
+	}
+	if chat.profiles[4] != "full" {
+		t.Errorf("demoted follower must get profile again, got %q", chat.profiles[4])
+	}
+	if mute, _ := chat.muted(5); !mute {
+		t.Error("unfollowed moderator must be demoted and restricted")
+	}
+	if !reflect.DeepEqual(store.roles, map[int]string{1: "moderator", 2: "vip"}) {
+		t.Errorf("stored roles %v", store.roles)
+	}
+	for _, action := range store.actions(6) {
+		if action == storage.AuditRole {
+			t.Error("admin appointed by hand must keep own rights and title")
+		}
+	}
+
+	// second sweep change nothing
+	audited := len(store.audit)
+	if err := bot.CheckPermissions(false); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.audit) != audited {
+		t.Errorf("roles reconciled again: %v", store.audit[audited:])
+	}
+
+	// statuses unavailable, roles kept
+	app.errStatuses = errors.New("missing scope")
+	if err := bot.CheckPermissions(false); err != nil {
+		t.Fatal(err)
+	}
+	if chat.role(1) != "Mod" {
+		t.Error("role must be kept when statuses unavailable")
+	}
+}
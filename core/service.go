@@ -0,0 +1,754 @@
+// Package core gate chat (telegram group, other ChatPlatform) by identity provider entitlement (twitch follow, patreon pledge):
+// link users by oauth callback, sweep linked users and restrict who lost entitlement
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/leporel/ttg/storage"
+	"github.com/patrickmn/go-cache"
+	"github.com/throttled/throttled/v2"
+	"github.com/throttled/throttled/v2/store/memstore"
+)
+
+// sweepInterval how often linked users checked
+const sweepInterval = 30 * time.Minute
+
+// tokenValidateInterval twitch require validate tokens at least once per hour, other providers refresh ahead
+const tokenValidateInterval = time.Hour
+
+type httpHandler func(http.ResponseWriter, *http.Request) error
+
+// Service gating service, use New to create
+type Service struct {
+	cfg   Config
+	app   IdentityProvider
+	chat  ChatPlatform
+	db    Store
+	ready bool
+	mu    *sync.Mutex
+
+	cache  *cache.Cache
+	notify *notifier
+
+	// Last sweep aborted by circuit breaker and wait owner confirmation
+	breakerTripped bool
+}
+
+// New create service, chat receive commands by Handle after Run
+func New(cfg Config, app IdentityProvider, chat ChatPlatform, db Store) *Service {
+	if cfg.Messages == nil {
+		cfg.Messages = NewMessages()
+	}
+	if cfg.Pages == nil {
+		cfg.Pages = NewPages()
+	}
+	if cfg.DigestInterval <= 0 {
+		cfg.DigestInterval = DefaultDigestInterval
+	}
+
+	b := &Service{
+		cfg:   cfg,
+		app:   app,
+		chat:  chat,
+		db:    db,
+		mu:    &sync.Mutex{},
+		cache: cache.New(time.Minute*10, time.Minute*1),
+	}
+
+	b.notify = newNotifier(cfg.Notify, func(msg string) {
+		b.chat.SendOwner(msg)
+	})
+
+	return b
+}
+
+// Run start chat, sweeps and callback server on cfg.Addr, block until ctx done
+func (b *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	validate := time.NewTicker(tokenValidateInterval)
+	defer validate.Stop()
+
+	var digest <-chan time.Time
+	if b.cfg.Notify == NotifyDigest {
+		digestTicker := time.NewTicker(b.cfg.DigestInterval)
+		defer digestTicker.Stop()
+		digest = digestTicker.C
+	}
+
+	// snapshot before chat started, members seen after are not grandfathered
+	if b.cfg.Init {
+		if err := b.initSeenMembers(); err != nil {
+			log.Println("ERROR: ", err)
+		}
+	}
+
+	b.ready = true
+
+	log.Printf("Started %s chat, gated by %s \n", b.chat.Name(), b.app.Name())
+	go func() {
+		b.chat.Start(b.Handle)
+	}()
+	defer b.chat.Stop()
+
+	if b.cfg.Addr != "" {
+		handler, err := b.CallbackHandler()
+		if err != nil {
+			log.Println("ERROR: ", err)
+			return
+		}
+		srv := &http.Server{Addr: b.cfg.Addr, Handler: handler}
+		go func() {
+			log.Printf("Started listening on http://%s/auth/callback \n", b.cfg.Addr)
+			if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+				log.Println("ERROR: ", err)
+			}
+		}()
+		defer srv.Close()
+	}
+
+	if err := b.ReconcileMembers(); err != nil {
+		log.Println("ERROR: ", err)
+	}
+
+	for {
+		select {
+		case _ = <-ticker.C:
+			if err := b.CheckPermissions(false); err != nil {
+				log.Println("ERROR: ", err)
+			}
+			if err := b.ReconcileMembers(); err != nil {
+				log.Println("ERROR: ", err)
+			}
+		case _ = <-digest:
+			b.notify.flush()
+		case _ = <-validate.C:
+			if err := b.app.ValidateTokens(); err != nil {
+				log.Println("ERROR: ", err)
+				b.notify.add(NotifyAPIFailure, "validate tokens: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// CallbackHandler rate limited handler of oauth2 redirects on /auth/callback,
+// to mount on own server when cfg.Addr is empty
+func (b *Service) CallbackHandler() (http.Handler, error) {
+	store, err := memstore.New(65536)
+	if err != nil {
+		return nil, err
+	}
+	quota := throttled.RateQuota{
+		MaxRate:  throttled.PerMin(30),
+		MaxBurst: 5,
+	}
+	rateLimiter, err := throttled.NewGCRARateLimiter(store, quota)
+	if err != nil {
+		return nil, err
+	}
+	httpRateLimiter := throttled.HTTPRateLimiter{
+		DeniedHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b.page(w, r, outcomeTooMany, nil)
+		}),
+		RateLimiter: rateLimiter,
+		VaryBy:      &throttled.VaryBy{RemoteAddr: true},
+	}
+
+	// https://github.com/twitchdev/authentication-go-sample/blob/main/oauth-authorization-code/main.go
+	var middleware = func(h httpHandler) httpHandler {
+		return func(w http.ResponseWriter, r *http.Request) (err error) {
+			// parse POST body
+			if err = r.ParseForm(); err != nil {
+				return err
+			}
+
+			return h(w, r)
+		}
+	}
+
+	var errorHandling = func(handler func(w http.ResponseWriter, r *http.Request) error) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := handler(w, r); err != nil {
+				log.Println(err)
+				b.page(w, r, outcomeError, nil)
+				return
+			}
+		})
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/auth/callback", httpRateLimiter.RateLimit(errorHandling(middleware(b.handleOAuth2Callback))))
+
+	return mux, nil
+}
+
+func (b *Service) handleOAuth2Callback(w http.ResponseWriter, r *http.Request) error {
+	if !b.ready {
+		b.page(w, r, outcomeNotReady, nil)
+		return nil
+	}
+
+	state := r.FormValue("state")
+	if _, err := uuid.Parse(state); err != nil {
+		b.page(w, r, outcomeBadRequest, nil)
+		return nil
+	}
+
+	cached, found := b.cache.Get(state)
+	if !found {
+		b.page(w, r, outcomeExpired, nil)
+		return nil
+	}
+
+	// user denied access on provider page
+	if r.FormValue("error") != "" {
+		b.cache.Delete(state)
+		b.page(w, r, outcomeCancelled, Args{"Provider": b.app.Name()})
+		return nil
+	}
+
+	switch st := cached.(type) {
+	case connectState:
+		b.cache.Delete(state)
+		return b.handleConnect(w, r, st)
+	case relinkState:
+		b.cache.Delete(state)
+		return b.handleRelink(w, r, st)
+	case int:
+		return b.handleLink(w, r, st)
+	}
+
+	return fmt.Errorf("unknown state %T", cached)
+}
+
+// handleLink link twitch account of callback to telegram user, provider requests run concurrently,
+// checks and writes under lock with commands, sweeps and other callbacks
+func (b *Service) handleLink(w http.ResponseWriter, r *http.Request, tgID int) error {
+	accessToken, user, err := b.app.Authorize(r.FormValue("code"))
+	if err != nil {
+		return err
+	}
+
+	twID, err := strconv.Atoi(user.ID)
+	if err != nil {
+		return err
+	}
+
+	entitled, err := b.app.Entitled(accessToken, user)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	found, err := b.checkUserTelegram(tgID)
+	if err != nil {
+		return err
+	}
+	if found {
+		b.page(w, r, outcomeTelegramLinked, Args{"Provider": b.app.Name()})
+		return nil
+	}
+
+	found, err = b.checkUserTwitch(twID)
+	if err != nil {
+		return err
+	}
+	if found {
+		b.page(w, r, outcomeAccountTaken, Args{"Provider": b.app.Name()})
+		return nil
+	}
+
+	if err := b.cooldown(twAccount(twID)); err != nil {
+		var ce *CooldownError
+		if !errors.As(err, &ce) {
+			return err
+		}
+		b.page(w, r, outcomeCooldown, Args{"Until": ce.Until.Format(time.RFC822)})
+		return nil
+	}
+
+	if !entitled {
+		b.page(w, r, outcomeNotEligible, Args{"Provider": b.app.Name()})
+		return nil
+	}
+
+	if err := b.addUser(tgID, twID, user.Name); err != nil {
+		return err
+	}
+
+	b.page(w, r, outcomeLinked, nil)
+
+	return nil
+}
+
+// connectState cached state of broadcaster oauth flow started by owner
+type connectState struct {
+	OwnerID int
+}
+
+func (b *Service) handleConnect(w http.ResponseWriter, r *http.Request, cs connectState) error {
+	token, err := b.app.Connect(r.FormValue("code"))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Broadcaster token connected by [%s]\n", token.Login)
+	b.chat.SendUser(cs.OwnerID, b.app.CapabilitiesReport())
+
+	b.page(w, r, outcomeConnected, nil)
+
+	return nil
+}
+
+// Handle process chat command, passed to ChatPlatform.Start
+func (b *Service) Handle(command Command, payload Data) (string, error) {
+	if !b.ready {
+		return b.text(payload.Language, MsgNotReady, nil), nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// language of user himself, messages sent to him later use it
+	switch command {
+	case CommandGetLink, CommandRelink, CommandSeenUser, CommandMyData:
+		b.rememberLanguage(payload.UserID, payload.Language)
+	}
+
+	switch command {
+	case CommandAddWhiteList:
+		err := b.addWhiteList(payload.UserID, payload.Actor, "manual added")
+		if err != nil {
+			return "", err
+		}
+		b.chat.SendUser(payload.UserID, b.userText(payload.UserID, MsgWhiteListed, nil))
+
+		return fmt.Sprintf("User %v added in white list", payload.UserID), nil
+
+	case CommandGetLink:
+		if err := b.cooldown(tgAccount(payload.UserID)); err != nil {
+			return "", err
+		}
+
+		return b.authLink(fmt.Sprint(payload.UserID), payload.UserID, payload.Language)
+
+	case CommandRelink:
+		if err := b.cooldown(tgAccount(payload.UserID)); err != nil {
+			return "", err
+		}
+
+		return b.authLink(fmt.Sprintf("relink:%d", payload.UserID), relinkState{TelegramID: payload.UserID}, payload.Language)
+
+	case CommandUnlink:
+		return b.unlink(payload.UserID, payload.Actor)
+
+	case CommandMyData:
+		return b.myData(payload.UserID)
+
+	case CommandForgetMe:
+		return b.forget(payload.UserID)
+
+	case CommandCheckWhiteList:
+		exist, err := b.checkWhiteList(payload.UserID)
+		if err != nil {
+			return "", err
+		}
+		if exist {
+			return "exist", nil
+		}
+		return "none", nil
+
+	case CommandCheckUser:
+		exist, err := b.checkUserTelegram(payload.UserID)
+		if err != nil {
+			return "", err
+		}
+		if exist {
+			return "exist", nil
+		}
+		return "none", nil
+
+	case CommandSeenUser:
+		err := b.db.SaveMember(&storage.Member{
+			TelegramID: payload.UserID,
+			Username:   payload.Username,
+			SeenAt:     time.Now(),
+		})
+		if err != nil {
+			return "", err
+		}
+		if err := b.db.DeleteLeaver(payload.UserID); err != nil {
+			return "", err
+		}
+		if err := b.seenMember(payload.UserID); err != nil {
+			return "", err
+		}
+		return "ok", nil
+
+	case CommandLeftUser:
+		if err := b.leave(payload.UserID); err != nil {
+			return "", err
+		}
+		return "ok", nil
+
+	case CommandResolveUsername:
+		member, err := b.db.GetMemberByUsername(payload.Username)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return "none", nil
+			}
+			return "", err
+		}
+		return fmt.Sprint(member.TelegramID), nil
+
+	case CommandRestrict:
+		applied, err := b.setRights(payload.UserID, 0, b.cfg.Profiles.restricted())
+		if err != nil {
+			return "", err
+		}
+		if applied {
+			b.audit(storage.AuditRestrict, payload.UserID, 0, payload.Actor, payload.Reason)
+		}
+		return "ok", nil
+
+	case CommandRegrant:
+		profile := b.cfg.Profiles.entitled(nil)
+		twID := 0
+		user, err := b.db.GetUserByTgId(payload.UserID)
+		switch {
+		case err == nil:
+			twID = user.TwitchID
+			profile = b.entitledProfile(twID)
+		case err != sql.ErrNoRows:
+			return "", err
+		}
+
+		applied, err := b.setRights(payload.UserID, twID, profile)
+		if err != nil {
+			return "", err
+		}
+		if applied {
+			b.audit(storage.AuditGrant, payload.UserID, twID, payload.Actor, payload.Reason)
+		}
+		return "ok", nil
+
+	case CommandInit:
+		count, err := b.initMembers(payload.UserIDs, payload.Actor)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Grandfathered %d members", count), nil
+
+	case CommandAudit:
+		b.audit(payload.Action, payload.UserID, 0, payload.Actor, payload.Reason)
+		return "ok", nil
+
+	case CommandAuditLog:
+		return b.auditLog(payload.UserID, 20)
+
+	case CommandAuditExport:
+		return b.auditExport()
+
+	case CommandConnect:
+		uid := uuid.New().String()
+
+		b.cache.SetDefault(uid, connectState{OwnerID: payload.UserID})
+
+		link := b.app.GetConnectLink(uid)
+
+		rsp := fmt.Sprintf("Open [link](%s) as %s channel owner, to give bot access to eligible users\\. "+
+			"Send /capabilities after to check enabled features", link, b.app.Name())
+
+		return rsp, nil
+
+	case CommandCapabilities:
+		return b.app.CapabilitiesReport(), nil
+
+	case CommandConfirmSweep:
+		if !b.breakerTripped {
+			return "Nothing to confirm, last sweep was not aborted", nil
+		}
+
+		go b.confirmSweep()
+
+		return "Sweep confirmed, running", nil
+	}
+
+	return "Unknown command", nil
+}
+
+// CheckPermissions sweep linked users, force ignore circuit breaker
+func (b *Service) CheckPermissions(force bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	users, err := b.db.GetUsers()
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		b.notify.add(NotifyError, "sweep: %v", err)
+		return err
+	}
+
+	if len(users) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(users))
+	for twitchID := range users {
+		ids = append(ids, twitchID)
+	}
+
+	followers, total, err := b.app.GetEntitled(ids)
+	if err != nil {
+		b.notify.add(NotifyAPIFailure, "get eligible users: %v", err)
+		return err
+	}
+
+	graces, err := b.db.GetGraces()
+	if err != nil {
+		b.notify.add(NotifyError, "sweep: %v", err)
+		return err
+	}
+
+	missing := 0
+	for twitchID := range users {
+		if _, exist := followers[twitchID]; !exist {
+			missing++
+		}
+	}
+
+	if !force && breakerTripped(len(users), missing, total, b.cfg.BreakerThreshold, b.cfg.BreakerMin) {
+		return b.tripBreaker(len(users), missing, total)
+	}
+	b.breakerTripped = false
+
+	for twitchID, tgID := range users {
+		if _, exist := followers[twitchID]; exist {
+			if _, found := graces[tgID]; found {
+				if err := b.db.DeleteGrace(tgID); err != nil {
+					log.Println("ERROR: ", err)
+				}
+			}
+			continue
+		}
+
+		log.Printf("Not eligible: %s \n", twitchID)
+		twID, _ := strconv.Atoi(twitchID)
+
+		revoke, err := b.strike(tgID, twID, graces[tgID])
+		if err != nil {
+			log.Println("ERROR: ", err)
+			b.notify.add(NotifyError, "grace tg:%d: %v", tgID, err)
+			continue
+		}
+		if !revoke {
+			continue
+		}
+
+		if err := b.removeUser(tgID, twID, storage.ActorBot, "not eligible on "+b.app.Name()); err != nil {
+			log.Println("ERROR: ", err)
+			b.notify.add(NotifyError, "remove tg:%d: %v", tgID, err)
+		}
+	}
+
+	entitled := make(map[string]int, len(followers))
+	for twitchID, tgID := range users {
+		if _, exist := followers[twitchID]; exist {
+			entitled[twitchID] = tgID
+		}
+	}
+	b.reconcile(entitled)
+
+	return nil
+}
+
+// authLink message with oauth link with state in language, same link returned for key until it expired
+func (b *Service) authLink(key string, state interface{}, language string) (string, error) {
+	uid := uuid.New().String()
+
+	err := b.cache.Add(key, uid, cache.DefaultExpiration)
+	if err == nil {
+		err := b.cache.Add(uid, state, cache.DefaultExpiration)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		uidI, found := b.cache.Get(key)
+		if !found {
+			return "", fmt.Errorf("uid cache not found")
+		}
+		uid = uidI.(string)
+	}
+
+	link, err := b.app.GetAuthLink(uid)
+	if err != nil {
+		return "", err
+	}
+
+	return b.text(language, MsgLink, Args{"Link": html.EscapeString(link)}), nil
+}
+
+func (b *Service) addUser(tgID, twID int, name string) error {
+	log.Printf("Add user [%s]\n", name)
+
+	err := b.db.AddUser(&storage.User{
+		TelegramID: tgID,
+		TwitchID:   twID,
+		Name:       name,
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return b.linked(tgID, twID, name)
+}
+
+// linked audit new link and apply entitled profile to user
+func (b *Service) linked(tgID, twID int, name string) error {
+	b.audit(storage.AuditLink, tgID, twID, tgID, name)
+
+	b.notify.add(NotifyLink, "tg:%d tw:%d (%s)", tgID, twID, name)
+
+	profile := b.entitledProfile(twID)
+	applied, err := b.setRights(tgID, twID, profile)
+	if err != nil {
+		return err
+	}
+	if !applied {
+		b.chat.SendUser(tgID, b.userText(tgID, MsgLinkedRestricted, nil))
+		return nil
+	}
+	b.audit(storage.AuditGrant, tgID, twID, tgID, "eligible on "+b.app.Name()+", profile "+profile.Name)
+
+	return nil
+}
+
+func (b *Service) removeUser(tgID, twID, actor int, reason string) error {
+	log.Printf("Remove user id [%v]\n", tgID)
+
+	err := b.db.DeleteUser(tgID)
+	if err == sql.ErrNoRows {
+		log.Printf("User id [%v] already removed\n", tgID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return b.unlinked(tgID, twID, actor, reason)
+}
+
+// unlinked forget grace and role of unlinked user and restrict him
+func (b *Service) unlinked(tgID, twID, actor int, reason string) error {
+	err := b.db.DeleteGrace(tgID)
+	if err != nil {
+		return err
+	}
+	b.audit(storage.AuditUnlink, tgID, twID, actor, reason)
+
+	if err = b.demote(tgID); err != nil {
+		return err
+	}
+
+	b.notify.add(NotifyRevoke, "tg:%d tw:%d (%s)", tgID, twID, reason)
+
+	applied, err := b.setRights(tgID, twID, b.cfg.Profiles.restricted())
+	if errors.Is(err, ErrNotMember) {
+		// restricted as unknown member if rejoin
+		log.Printf("User [%d] not in chat, not restricted\n", tgID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if applied {
+		b.audit(storage.AuditRestrict, tgID, twID, actor, reason)
+	}
+
+	return nil
+}
+
+func (b *Service) addWhiteList(userID, actor int, dcs string) error {
+	err := b.db.AddWhiteList(&storage.WhiteListedUser{
+		TelegramID:  userID,
+		Description: dcs,
+	})
+	if err != nil {
+		return err
+	}
+	b.audit(storage.AuditWhiteList, userID, 0, actor, dcs)
+
+	applied, err := b.setRights(userID, 0, b.cfg.Profiles.entitled(nil))
+	if err != nil {
+		return err
+	}
+	if applied {
+		b.audit(storage.AuditGrant, userID, 0, actor, dcs)
+	}
+
+	return nil
+}
+
+func (b *Service) checkWhiteList(userID int) (bool, error) {
+	rs, err := b.db.GetWhiteListedUser(userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	if rs != nil {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (b *Service) checkUserTelegram(userID int) (bool, error) {
+	rs, err := b.db.GetUserByTgId(userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	if rs != nil {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (b *Service) checkUserTwitch(userID int) (bool, error) {
+	rs, err := b.db.GetUserByTwId(userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	if rs != nil {
+		return true, nil
+	}
+
+	return false, nil
+}
@@ -0,0 +1,583 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/leporel/ttg/storage"
+)
+
+func TestGraceExpired(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		grace  storage.Grace
+		sweeps int
+		period time.Duration
+		want   bool
+	}{
+		{"immediate", storage.Grace{Strikes: 1, FirstFailedAt: now}, 1, 0, true},
+		{"first strike", storage.Grace{Strikes: 1, FirstFailedAt: now}, 3, 0, false},
+		{"sweeps reached", storage.Grace{Strikes: 3, FirstFailedAt: now}, 3, 0, true},
+		{"period not passed", storage.Grace{Strikes: 5, FirstFailedAt: now.Add(-time.Hour)}, 0, 2 * time.Hour, false},
+		{"period passed", storage.Grace{Strikes: 2, FirstFailedAt: now.Add(-3 * time.Hour)}, 0, 2 * time.Hour, true},
+		{"period first", storage.Grace{Strikes: 2, FirstFailedAt: now.Add(-3 * time.Hour)}, 10, 2 * time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		if got := graceExpired(&tt.grace, tt.sweeps, tt.period, now); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBreakerTripped(t *testing.T) {
+	tests := []struct {
+		name      string
+		total     int
+		revoke    int
+		followers int
+		want      bool
+	}{
+		{"nothing to revoke", 100, 0, 5000, false},
+		{"few revocations", 100, 2, 5000, false},
+		{"below threshold", 100, 15, 5000, false},
+		{"above threshold", 100, 30, 5000, true},
+		{"empty followers", 2, 2, 0, true},
+		{"small group", 2, 2, 5000, false},
+	}
+
+	for _, tt := range tests {
+		if got := breakerTripped(tt.total, tt.revoke, tt.followers, 0.2, 3); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if breakerTripped(100, 100, 0, 0, 3) {
+		t.Error("disabled breaker must not trip")
+	}
+}
+
+// newOfflineTTG bot wired to fake twitch and telegram servers, no credentials and network required
+func TestService_handleOAuth2Callback(t *testing.T) {
+	const tgID = 42
+
+	// link state of user tgID
+	linkState := func(b *Service) string {
+		uid := uuid.New().String()
+		b.cache.SetDefault(uid, tgID)
+		return uid
+	}
+
+	tests := []struct {
+		name  string
+		state func(b *Service) string
+		code  string
+		// error oauth2 error parameter, e.g. access_denied
+		error   string
+		prepare func(b *Service, app *mockIdentity, chat *mockChat, store *mockStore)
+
+		wantErr    bool
+		wantStatus int
+		wantLinked bool
+	}{
+		{
+			name:       "not ready",
+			state:      linkState,
+			code:       "code-ok",
+			prepare:    func(b *Service, _ *mockIdentity, _ *mockChat, _ *mockStore) { b.ready = false },
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "missing state",
+			state:      func(_ *Service) string { return "" },
+			code:       "code-ok",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "wrong state",
+			state:      func(_ *Service) string { return "state" },
+			code:       "code-ok",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "expired state",
+			state:      func(_ *Service) string { return uuid.New().String() },
+			code:       "code-ok",
+			wantStatus: http.StatusGone,
+		},
+		{
+			name: "connect broadcaster",
+			state: func(b *Service) string {
+				uid := uuid.New().String()
+				b.cache.SetDefault(uid, connectState{OwnerID: 1000})
+				return uid
+			},
+			code:       "code-broadcaster",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "connect failed",
+			state: func(b *Service) string {
+				uid := uuid.New().String()
+				b.cache.SetDefault(uid, connectState{OwnerID: 1000})
+				return uid
+			},
+			code: "code-broadcaster",
+			prepare: func(_ *Service, app *mockIdentity, _ *mockChat, _ *mockStore) {
+				app.errConnect = errors.New("invalid code")
+			},
+			wantErr: true,
+		},
+		{
+			name:  "telegram user already linked",
+			state: linkState,
+			code:  "code-ok",
+			prepare: func(_ *Service, _ *mockIdentity, _ *mockChat, store *mockStore) {
+				store.users[tgID] = &storage.User{TelegramID: tgID, TwitchID: 8}
+			},
+			wantStatus: http.StatusConflict,
+			wantLinked: true,
+		},
+		{
+			name:  "storage failed",
+			state: linkState,
+			code:  "code-ok",
+			prepare: func(_ *Service, _ *mockIdentity, _ *mockChat, store *mockStore) {
+				store.fail["GetUserByTgId"] = errors.New("database is locked")
+			},
+			wantErr: true,
+		},
+		{
+			name:  "code exchange failed",
+			state: linkState,
+			code:  "code-ok",
+			prepare: func(_ *Service, app *mockIdentity, _ *mockChat, _ *mockStore) {
+				app.errToken = errors.New("invalid code")
+			},
+			wantErr: true,
+		},
+		{
+			name:  "get user failed",
+			state: linkState,
+			code:  "code-ok",
+			prepare: func(_ *Service, app *mockIdentity, _ *mockChat, _ *mockStore) {
+				app.errUser = errors.New("helix: 500")
+			},
+			wantErr: true,
+		},
+		{
+			name:    "not numeric twitch id",
+			state:   linkState,
+			code:    "code-badid",
+			wantErr: true,
+		},
+		{
+			name:  "twitch account linked to other user",
+			state: linkState,
+			code:  "code-ok",
+			prepare: func(_ *Service, _ *mockIdentity, _ *mockChat, store *mockStore) {
+				store.users[43] = &storage.User{TelegramID: 43, TwitchID: 5}
+			},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:  "follow check failed",
+			state: linkState,
+			code:  "code-ok",
+			prepare: func(_ *Service, app *mockIdentity, _ *mockChat, _ *mockStore) {
+				app.errFollows = errors.New("helix: 503")
+			},
+			wantErr: true,
+		},
+		{
+			name:       "access denied by user",
+			state:      linkState,
+			code:       "",
+			error:      "access_denied",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "not follower",
+			state:      linkState,
+			code:       "code-stranger",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:  "add user failed",
+			state: linkState,
+			code:  "code-ok",
+			prepare: func(_ *Service, _ *mockIdentity, _ *mockChat, store *mockStore) {
+				store.fail["AddUser"] = errors.New("constraint failed")
+			},
+			wantErr: true,
+		},
+		{
+			name:  "user left group",
+			state: linkState,
+			code:  "code-ok",
+			prepare: func(_ *Service, _ *mockIdentity, chat *mockChat, _ *mockStore) {
+				chat.absent[tgID] = true
+			},
+			wantErr:    true,
+			wantLinked: true,
+		},
+		{
+			name:       "linked",
+			state:      linkState,
+			code:       "code-ok",
+			wantStatus: http.StatusOK,
+			wantLinked: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &mockIdentity{
+				codes: map[string]string{"code-ok": "tok-ok", "code-stranger": "tok-stranger", "code-badid": "tok-badid"},
+				users: map[string]*Identity{
+					"tok-ok":       {ID: "5", Name: "User5"},
+					"tok-stranger": {ID: "9", Name: "User9"},
+					"tok-badid":    {ID: "abc", Name: "abc"},
+				},
+				followers: map[string]string{"5": "user5"},
+			}
+			bot, chat, store := newMockService(app)
+			if tt.prepare != nil {
+				tt.prepare(bot, app, chat, store)
+			}
+
+			query := url.Values{"state": {tt.state(bot)}, "code": {tt.code}}
+			if tt.error != "" {
+				query.Set("error", tt.error)
+			}
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/auth/callback?"+query.Encode(), nil)
+
+			err := bot.handleOAuth2Callback(rec, req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			_, linked := store.users[tgID]
+			if linked != tt.wantLinked {
+				t.Fatalf("linked %v, want %v", linked, tt.wantLinked)
+			}
+
+			if tt.wantLinked && !tt.wantErr && tt.wantStatus == http.StatusOK {
+				if mute, found := chat.muted(tgID); !found || mute {
+					t.Fatal("linked user must be allowed to send messages")
+				}
+				if got := store.actions(tgID); len(got) != 2 || got[0] != storage.AuditLink || got[1] != storage.AuditGrant {
+					t.Fatalf("audit %v, want link and grant", got)
+				}
+			}
+			if !tt.wantLinked {
+				if _, found := chat.muted(tgID); found {
+					t.Fatal("rights of not linked user must not change")
+				}
+			}
+		})
+	}
+
+	t.Run("connect reported to owner", func(t *testing.T) {
+		app := &mockIdentity{}
+		bot, chat, _ := newMockService(app)
+
+		uid := uuid.New().String()
+		bot.cache.SetDefault(uid, connectState{OwnerID: 1000})
+
+		req := httptest.NewRequest(http.MethodGet, "/auth/callback?state="+uid+"&code=code-broadcaster", nil)
+		if err := bot.handleOAuth2Callback(httptest.NewRecorder(), req); err != nil {
+			t.Fatal(err)
+		}
+		if app.connected == nil {
+			t.Fatal("broadcaster token must be connected")
+		}
+		if len(chat.messages[1000]) != 1 {
+			t.Fatalf("owner must get capabilities report, got %v", chat.messages[1000])
+		}
+		if _, found := bot.cache.Get(uid); found {
+			t.Fatal("connect state must be used once")
+		}
+	})
+}
+
+// run with -race: callbacks of same and different users, and sweep, served in parallel
+func TestService_concurrentCallbacks(t *testing.T) {
+	const (
+		users   = 10
+		same    = 1000
+		retries = 5
+	)
+
+	app := &mockIdentity{
+		codes:     make(map[string]string),
+		users:     make(map[string]*Identity),
+		followers: make(map[string]string),
+	}
+	for i := 1; i <= users; i++ {
+		app.codes[fmt.Sprintf("code-%d", i)] = fmt.Sprintf("tok-%d", i)
+		app.users[fmt.Sprintf("tok-%d", i)] = &Identity{ID: fmt.Sprint(i), Name: fmt.Sprintf("User%d", i)}
+		app.followers[fmt.Sprint(i)] = fmt.Sprintf("user%d", i)
+	}
+	app.codes["code-same"] = "tok-same"
+	app.users["tok-same"] = &Identity{ID: "500", Name: "User500"}
+	app.followers["500"] = "user500"
+
+	bot, chat, store := newMockService(app)
+	handler, err := bot.CallbackHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type callback struct {
+		query url.Values
+		code  int
+	}
+	var callbacks []*callback
+	for i := 1; i <= users; i++ {
+		state := uuid.New().String()
+		bot.cache.SetDefault(state, i)
+		callbacks = append(callbacks, &callback{query: url.Values{"state": {state}, "code": {fmt.Sprintf("code-%d", i)}}})
+	}
+	// same user opened link in several tabs
+	for i := 0; i < retries; i++ {
+		state := uuid.New().String()
+		bot.cache.SetDefault(state, same)
+		callbacks = append(callbacks, &callback{query: url.Values{"state": {state}, "code": {"code-same"}}})
+	}
+
+	var wg sync.WaitGroup
+	for i, cb := range callbacks {
+		wg.Add(1)
+		go func(i int, cb *callback) {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/auth/callback?"+cb.query.Encode(), nil)
+			req.RemoteAddr = fmt.Sprintf("10.0.0.%d:1234", i+1)
+			handler.ServeHTTP(rec, req)
+			cb.code = rec.Code
+		}(i, cb)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := bot.CheckPermissions(false); err != nil {
+			t.Error(err)
+		}
+	}()
+	wg.Wait()
+
+	for i, cb := range callbacks[:users] {
+		if cb.code != http.StatusOK {
+			t.Fatalf("user %d: status %d, want %d", i+1, cb.code, http.StatusOK)
+		}
+	}
+	codes := make(map[int]int)
+	for _, cb := range callbacks[users:] {
+		codes[cb.code]++
+	}
+	if codes[http.StatusOK] != 1 || codes[http.StatusConflict] != retries-1 {
+		t.Fatalf("same user must be linked once, others conflict, got %v", codes)
+	}
+
+	if len(store.users) != users+1 {
+		t.Fatalf("linked %d users, want %d", len(store.users), users+1)
+	}
+	for tgID := range store.users {
+		if mute, found := chat.muted(tgID); !found || mute {
+			t.Fatalf("linked user %d must be allowed to send messages", tgID)
+		}
+	}
+	if got := store.actions(same); len(got) != 2 || got[0] != storage.AuditLink || got[1] != storage.AuditGrant {
+		t.Fatalf("audit %v, want one link and grant", got)
+	}
+}
+
+func TestService_checkPermissions(t *testing.T) {
+	tests := []struct {
+		name string
+		// tgID -> twitchID of linked users
+		users     map[int]int
+		followers map[string]string
+		graces    map[int]*storage.Grace
+		sweeps    int
+		force     bool
+		prepare   func(app *mockIdentity, chat *mockChat, store *mockStore)
+
+		wantErr     bool
+		wantLinked  []int
+		wantMuted   []int
+		wantGraces  []int
+		wantWarned  []int
+		wantTripped bool
+	}{
+		{
+			name:      "no linked users",
+			followers: map[string]string{"101": "u101"},
+		},
+		{
+			name:  "storage failed",
+			users: map[int]int{1: 101},
+			prepare: func(_ *mockIdentity, _ *mockChat, store *mockStore) {
+				store.fail["GetUsers"] = errors.New("disk I/O error")
+			},
+			wantErr:    true,
+			wantLinked: []int{1},
+		},
+		{
+			name:  "twitch api failed",
+			users: map[int]int{1: 101},
+			prepare: func(app *mockIdentity, _ *mockChat, _ *mockStore) {
+				app.errFollowers = errors.New("broadcaster not connected")
+			},
+			wantErr:    true,
+			wantLinked: []int{1},
+		},
+		{
+			name:      "graces failed",
+			users:     map[int]int{1: 101},
+			followers: map[string]string{"101": "u101"},
+			prepare: func(_ *mockIdentity, _ *mockChat, store *mockStore) {
+				store.fail["GetGraces"] = errors.New("disk I/O error")
+			},
+			wantErr:    true,
+			wantLinked: []int{1},
+		},
+		{
+			name:       "all followers, grace cleared",
+			users:      map[int]int{1: 101, 2: 102},
+			followers:  map[string]string{"101": "u101", "102": "u102"},
+			graces:     map[int]*storage.Grace{2: {TelegramID: 2, Strikes: 1, FirstFailedAt: time.Now()}},
+			wantLinked: []int{1, 2},
+		},
+		{
+			name:       "unfollowed revoked",
+			users:      map[int]int{1: 101, 2: 102},
+			followers:  map[string]string{"101": "u101"},
+			wantLinked: []int{1},
+			wantMuted:  []int{2},
+		},
+		{
+			name:       "first strike warned",
+			users:      map[int]int{1: 101, 2: 102},
+			followers:  map[string]string{"101": "u101"},
+			sweeps:     3,
+			wantLinked: []int{1, 2},
+			wantGraces: []int{2},
+			wantWarned: []int{2},
+		},
+		{
+			name:       "grace over",
+			users:      map[int]int{1: 101, 2: 102},
+			followers:  map[string]string{"101": "u101"},
+			graces:     map[int]*storage.Grace{2: {TelegramID: 2, Strikes: 2, FirstFailedAt: time.Now()}},
+			sweeps:     3,
+			wantLinked: []int{1},
+			wantMuted:  []int{2},
+		},
+		{
+			name:        "breaker tripped",
+			users:       map[int]int{1: 101, 2: 102, 3: 103, 4: 104},
+			followers:   map[string]string{"999": "u999"},
+			wantErr:     true,
+			wantLinked:  []int{1, 2, 3, 4},
+			wantTripped: true,
+		},
+		{
+			name:      "breaker confirmed",
+			users:     map[int]int{1: 101, 2: 102, 3: 103, 4: 104},
+			followers: map[string]string{"999": "u999"},
+			force:     true,
+			wantMuted: []int{1, 2, 3, 4},
+		},
+		{
+			name:       "revoked user left group",
+			users:      map[int]int{1: 101, 2: 102},
+			followers:  map[string]string{"101": "u101"},
+			prepare:    func(_ *mockIdentity, chat *mockChat, _ *mockStore) { chat.absent[2] = true },
+			wantLinked: []int{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &mockIdentity{followers: tt.followers}
+			bot, chat, store := newMockService(app)
+			if tt.sweeps > 0 {
+				bot.cfg.GraceSweeps = tt.sweeps
+			}
+			for tgID, twID := range tt.users {
+				store.users[tgID] = &storage.User{TelegramID: tgID, TwitchID: twID}
+			}
+			for tgID, g := range tt.graces {
+				store.graces[tgID] = g
+			}
+			if tt.prepare != nil {
+				tt.prepare(app, chat, store)
+			}
+
+			err := bot.CheckPermissions(tt.force)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+
+			if got := sortedKeys(store.users); fmt.Sprint(got) != fmt.Sprint(orEmpty(tt.wantLinked)) {
+				t.Fatalf("linked %v, want %v", got, tt.wantLinked)
+			}
+
+			var muted []int
+			for tgID := range tt.users {
+				if mute, _ := chat.muted(tgID); mute {
+					muted = append(muted, tgID)
+				}
+			}
+			sort.Ints(muted)
+			if fmt.Sprint(orEmpty(muted)) != fmt.Sprint(orEmpty(tt.wantMuted)) {
+				t.Fatalf("muted %v, want %v", muted, tt.wantMuted)
+			}
+
+			var graces []int
+			for tgID := range store.graces {
+				graces = append(graces, tgID)
+			}
+			sort.Ints(graces)
+			if fmt.Sprint(orEmpty(graces)) != fmt.Sprint(orEmpty(tt.wantGraces)) {
+				t.Fatalf("graces %v, want %v", graces, tt.wantGraces)
+			}
+
+			for _, tgID := range tt.wantWarned {
+				if len(chat.messages[tgID]) != 1 {
+					t.Fatalf("user %d must be warned once, got %v", tgID, chat.messages[tgID])
+				}
+			}
+
+			if bot.breakerTripped != tt.wantTripped {
+				t.Fatalf("breaker tripped %v, want %v", bot.breakerTripped, tt.wantTripped)
+			}
+			if tt.wantTripped && len(chat.owner) != 1 {
+				t.Fatalf("owner must be alerted once, got %v", chat.owner)
+			}
+		})
+	}
+}
+
+func orEmpty(ids []int) []int {
+	if ids == nil {
+		return []int{}
+	}
+	return ids
+}
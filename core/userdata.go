@@ -0,0 +1,73 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/leporel/ttg/storage"
+)
+
+// myData everything stored about user as json
+func (b *Service) myData(tgID int) (string, error) {
+	data, err := b.db.ExportUser(tgID)
+	if err != nil {
+		return "", err
+	}
+
+	rs, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(rs), nil
+}
+
+// forget restrict user and delete everything stored about him, his ids removed from audit of other users,
+// only profile bot restricted him with kept. Deletion audited and reported without user ids, active cooldowns kept until they expire
+func (b *Service) forget(tgID int) (string, error) {
+	twID := 0
+	user, err := b.db.GetUserByTgId(tgID)
+	switch {
+	case err == nil:
+		twID = user.TwitchID
+	case err != sql.ErrNoRows:
+		return "", err
+	}
+
+	if err := b.demote(tgID); err != nil {
+		return "", err
+	}
+	restricted := b.cfg.Profiles.restricted()
+	applied, err := b.setRights(tgID, twID, restricted)
+	if err != nil && !errors.Is(err, ErrNotMember) && !errors.Is(err, ErrChatAdmin) {
+		return "", err
+	}
+
+	// language deleted too
+	rs := b.userText(tgID, MsgForgotten, nil)
+
+	deleted, anonymised, err := b.db.ForgetUser(tgID)
+	if err != nil {
+		return "", err
+	}
+	b.cache.Delete(fmt.Sprintf("lang:%d", tgID))
+
+	// bot restriction remembered without user data, or it is taken as manual and never lifted on link
+	if applied {
+		if err := b.db.SaveProfile(tgID, restricted.Name); err != nil {
+			return "", err
+		}
+	}
+
+	log.Println("User data deleted by request")
+	b.audit(storage.AuditForget, 0, 0, storage.ActorBot,
+		fmt.Sprintf("user data deleted by request, audit entries removed: %d, anonymised: %d", deleted, anonymised))
+	if user != nil {
+		b.notify.add(NotifyRevoke, "linked user deleted his data")
+	}
+
+	return rs, nil
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leporel/ttg/storage"
+)
+
+func TestService_myData(t *testing.T) {
+	bot, _, store := newMockService(&mockIdentity{})
+	store.users[1] = &storage.User{TelegramID: 1, TwitchID: 101, Name: "user1", CreatedAt: time.Now()}
+	store.members[1] = &storage.Member{TelegramID: 1, Username: "tg_user1", SeenAt: time.Now()}
+	store.profiles[1] = "full"
+	bot.audit(storage.AuditLink, 1, 101, storage.ActorBot, "")
+	bot.audit(storage.AuditLink, 2, 102, storage.ActorBot, "")
+	bot.audit(storage.AuditUnlink, 3, 103, 1, "relinked to tg:1")
+
+	response, err := bot.Handle(CommandMyData, Data{UserID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data storage.UserData
+	if err := json.Unmarshal([]byte(response), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Link == nil || data.Link.Name != "user1" || data.Member == nil || data.Profile != "full" {
+		t.Fatalf("wrong export %s", response)
+	}
+	if len(data.Audit) != 1 || data.Audit[0].TwitchID != 101 {
+		t.Fatalf("only user audit must be exported, got %v", data.Audit)
+	}
+	if len(data.AuditMentions) != 1 || data.AuditMentions[0].TelegramID != 3 {
+		t.Fatalf("audit of other users made by user must be exported, got %v", data.AuditMentions)
+	}
+}
+
+func TestService_forget(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(chat *mockChat, store *mockStore)
+		// wantNotify owner notified about linked user
+		wantNotify bool
+	}{
+		{
+			name: "linked user",
+			prepare: func(_ *mockChat, store *mockStore) {
+				store.users[1] = &storage.User{TelegramID: 1, TwitchID: 101, Name: "user1", CreatedAt: time.Now()}
+				store.graces[1] = &storage.Grace{TelegramID: 1, Strikes: 1}
+			},
+			wantNotify: true,
+		},
+		{
+			name: "whitelisted user",
+			prepare: func(_ *mockChat, store *mockStore) {
+				store.whitelist[1] = &storage.WhiteListedUser{TelegramID: 1, Description: "manual added"}
+			},
+		},
+		{
+			name:    "user not in chat",
+			prepare: func(chat *mockChat, _ *mockStore) { chat.absent[1] = true },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot, chat, store := newMockService(&mockIdentity{
+				codes:     map[string]string{"code-ok": "tok-ok"},
+				users:     map[string]*Identity{"tok-ok": {ID: "101", Name: "user1"}},
+				followers: map[string]string{"101": "user1"},
+			})
+			bot.notify = newNotifier(NotifyEvent, func(msg string) {
+				chat.SendOwner(msg)
+			})
+			store.members[1] = &storage.Member{TelegramID: 1, Username: "tg_user1", SeenAt: time.Now()}
+			store.cooldowns["tg:1"] = time.Now().Add(time.Hour)
+			bot.audit(storage.AuditLink, 1, 101, storage.ActorBot, "")
+			// user relinked account of other user
+			bot.audit(storage.AuditUnlink, 2, 101, 1, "relinked to tg:1")
+			tt.prepare(chat, store)
+
+			if _, err := bot.Handle(CommandForgetMe, Data{UserID: 1}); err != nil {
+				t.Fatal(err)
+			}
+
+			if !chat.absent[1] {
+				if mute, _ := chat.muted(1); !mute {
+					t.Fatal("user must be restricted")
+				}
+			}
+			data, err := store.ExportUser(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data.Link != nil || data.WhiteList != nil || data.Member != nil || data.Grace != nil ||
+				len(data.Audit) != 0 || len(data.AuditMentions) != 0 {
+				t.Fatalf("user data must be deleted, got %+v", data)
+			}
+			// restriction of bot kept, so it is lifted on link
+			if want := map[bool]string{false: "read_only", true: ""}[chat.absent[1]]; data.Profile != want {
+				t.Fatalf("profile %q, want %q", data.Profile, want)
+			}
+			if _, found := store.cooldowns["tg:1"]; !found {
+				t.Fatal("cooldown must be kept until expired")
+			}
+
+			if len(store.audit) != 2 || store.audit[1].Action != storage.AuditForget {
+				t.Fatalf("only forget must be audited, got %v", store.audit)
+			}
+			if e := store.audit[0]; e.Actor != storage.ActorDeleted || e.TwitchID != 0 || e.Reason != "relinked to tg:deleted" {
+				t.Fatalf("audit of other user must be anonymised, got %v", e.String())
+			}
+			e := store.audit[1]
+			if e.TelegramID != 0 || e.TwitchID != 0 || e.Reason != "user data deleted by request, audit entries removed: 1, anonymised: 1" {
+				t.Fatalf("forget must be audited without user ids, got %v", e.String())
+			}
+
+			notified := false
+			for _, msg := range chat.owner {
+				if strings.Contains(msg, "tg:1") {
+					t.Fatalf("owner notification must not contain user ids: %q", msg)
+				}
+				if strings.HasPrefix(msg, "Revoked") {
+					notified = true
+				}
+			}
+			if notified != tt.wantNotify {
+				t.Fatalf("owner notified %v, want %v", notified, tt.wantNotify)
+			}
+
+			// link again after cooldown expired, rights given back without conflict
+			if chat.absent[1] {
+				return
+			}
+			delete(store.cooldowns, "tg:1")
+			if _, err := bot.Handle(CommandGetLink, Data{UserID: 1}); err != nil {
+				t.Fatal(err)
+			}
+			uid, _ := bot.cache.Get("1")
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/auth/callback?state=%s&code=code-ok", uid), nil)
+			if err := bot.handleOAuth2Callback(rec, req); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+			}
+			if mute, _ := chat.muted(1); mute {
+				t.Fatal("linked user must get rights back")
+			}
+			for _, action := range store.actions(1) {
+				if action == storage.AuditConflict {
+					t.Fatal("restriction of bot must not be taken as manual")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package fake
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/leporel/ttg/core"
+)
+
+var _ core.ChatPlatform = (*Chat)(nil)
+var _ core.RoleSetter = (*Chat)(nil)
+
+// Chat in-memory chat platform, commands pushed by Command, rights and messages recorded
+type Chat struct {
+	mu      sync.Mutex
+	handler core.Handler
+	started chan struct{}
+
+	// userID -> profile, absent users not in chat
+	rights   map[int]core.Profile
+	manual   map[int]bool
+	roles    map[int]string
+	members  map[int]bool
+	messages map[int][]string
+	owner    []string
+}
+
+// NewChat empty chat, users must Join before their rights can be changed
+func NewChat() *Chat {
+	return &Chat{
+		started:  make(chan struct{}),
+		rights:   make(map[int]core.Profile),
+		manual:   make(map[int]bool),
+		roles:    make(map[int]string),
+		members:  make(map[int]bool),
+		messages: make(map[int][]string),
+	}
+}
+
+// Name of chat platform
+func (c *Chat) Name() string {
+	return "Fake"
+}
+
+// Start remember handler, commands passed to it by Command
+func (c *Chat) Start(handler core.Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.handler = handler
+	close(c.started)
+}
+
+// Stop do nothing, chat has no updates loop
+func (c *Chat) Stop() {}
+
+// Join add user to chat, new user is muted like in gated group
+func (c *Chat) Join(userID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.members[userID] = true
+	c.rights[userID] = core.ProfileReadOnly
+}
+
+// Leave remove user from chat, rights and role are lost
+func (c *Chat) Leave(userID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.members, userID)
+	delete(c.rights, userID)
+	delete(c.roles, userID)
+}
+
+// Restrict mute user like chat admin does, bot can not change his rights until Unrestrict
+func (c *Chat) Restrict(userID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.manual[userID] = true
+	c.rights[userID] = core.Profile{Name: "manual"}
+}
+
+// Unrestrict lift admin restriction, user keep muted until bot set his rights
+func (c *Chat) Unrestrict(userID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.manual, userID)
+}
+
+// SetRights apply profile to user, error for users not in chat or restricted by admin
+func (c *Chat) SetRights(userID int, profile core.Profile, _ *core.Profile) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.members[userID] {
+		return core.ErrNotMember
+	}
+	if c.manual[userID] {
+		return core.ErrManualRestriction
+	}
+	c.rights[userID] = profile
+
+	return nil
+}
+
+// Muted user can't write in chat
+func (c *Chat) Muted(userID int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.rights[userID].Muted()
+}
+
+// Profile name of profile applied to user
+func (c *Chat) Profile(userID int) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.rights[userID].Name
+}
+
+// SetRole give user role title, nil role remove it
+func (c *Chat) SetRole(userID int, role *core.Role, _ *core.Role) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.members[userID] {
+		return core.ErrNotMember
+	}
+	if role == nil {
+		delete(c.roles, userID)
+		return nil
+	}
+	c.roles[userID] = role.Title
+
+	return nil
+}
+
+// Role title of user role, empty if user has no role
+func (c *Chat) Role(userID int) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.roles[userID]
+}
+
+// SendUser record private message
+func (c *Chat) SendUser(userID int, msg interface{}, _ ...interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.messages[userID] = append(c.messages[userID], fmt.Sprint(msg))
+}
+
+// SendOwner record owner message
+func (c *Chat) SendOwner(msg interface{}, _ ...interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.owner = append(c.owner, fmt.Sprint(msg))
+}
+
+// Messages private messages sent to user
+func (c *Chat) Messages(userID int) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.messages[userID]...)
+}
+
+// OwnerMessages messages sent to owner
+func (c *Chat) OwnerMessages() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.owner...)
+}
+
+// Command pass command to service handler, wait service started
+func (c *Chat) Command(command core.Command, payload core.Data) (string, error) {
+	<-c.started
+
+	c.mu.Lock()
+	handler := c.handler
+	c.mu.Unlock()
+
+	return handler(command, payload)
+}
@@ -0,0 +1,409 @@
+// Package fake run local twitch helix/oauth2 and telegram bot api servers, for tests without network
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+type Follower struct {
+	UserID     string    `json:"user_id"`
+	UserLogin  string    `json:"user_login"`
+	UserName   string    `json:"user_name"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+type pagination struct {
+	Cursor string `json:"cursor"`
+}
+
+type followersResponse struct {
+	Total      int        `json:"total"`
+	Data       []Follower `json:"data"`
+	Pagination pagination `json:"pagination"`
+}
+
+type followedChannel struct {
+	BroadcasterID string `json:"broadcaster_id"`
+}
+
+type followedResponse struct {
+	Total int               `json:"total"`
+	Data  []followedChannel `json:"data"`
+}
+
+type channelUser struct {
+	UserID    string `json:"user_id"`
+	UserLogin string `json:"user_login"`
+	Tier      string `json:"tier,omitempty"`
+}
+
+type channelUsersResponse struct {
+	Data       []channelUser `json:"data"`
+	Pagination pagination    `json:"pagination"`
+}
+
+type usersResponse struct {
+	Data []helix.User `json:"data"`
+}
+
+type tokenResponse struct {
+	AccessToken  string   `json:"access_token"`
+	RefreshToken string   `json:"refresh_token"`
+	ExpiresIn    int      `json:"expires_in"`
+	Scopes       []string `json:"scope"`
+}
+
+type validateResponse struct {
+	ExpiresIn int `json:"expires_in"`
+}
+
+type helixError struct {
+	Status  int    `json:"status"`
+	Err     string `json:"error"`
+	Message string `json:"message"`
+}
+
+// Helix local helix server with oauth2, users, channels/followers and channels/followed endpoints
+type Helix struct {
+	BroadcasterID string
+	// Channel login resolved to BroadcasterID
+	Channel string
+	// Moderator current broadcaster token, refresh token is always "mod-refresh"
+	Moderator string
+	Followers []Follower
+	// user token -> user id
+	Users map[string]string
+	// authorization code -> user id
+	Codes map[string]string
+	// user id -> subscription tier (1000, 2000, 3000)
+	Subs map[string]string
+	VIPs []string
+	Mods []string
+
+	mu       sync.Mutex
+	requests int
+	issued   int
+	appToken string
+}
+
+// Handler serve helix api on root and oauth2 on /oauth2
+func (f *Helix) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		f.issued++
+		rs := tokenResponse{ExpiresIn: 3600}
+
+		switch r.FormValue("grant_type") {
+		case "client_credentials":
+			f.appToken = fmt.Sprintf("app-token-%d", f.issued)
+			rs.AccessToken = f.appToken
+		case "authorization_code":
+			userID, found := f.Codes[r.FormValue("code")]
+			if !found {
+				writeHelixError(w, http.StatusBadRequest, "Invalid authorization code")
+				return
+			}
+			rs.AccessToken = fmt.Sprintf("user-token-%s", userID)
+			rs.RefreshToken = fmt.Sprintf("user-refresh-%s", userID)
+			f.Users[rs.AccessToken] = userID
+		case "refresh_token":
+			if r.FormValue("refresh_token") != "mod-refresh" {
+				writeHelixError(w, http.StatusBadRequest, "Invalid refresh token")
+				return
+			}
+			f.Moderator = fmt.Sprintf("mod-token-%d", f.issued)
+			rs.AccessToken = f.Moderator
+			rs.RefreshToken = "mod-refresh"
+			rs.Scopes = []string{"moderator:read:followers"}
+		default:
+			writeHelixError(w, http.StatusBadRequest, "unsupported grant_type")
+			return
+		}
+
+		writeJSON(w, rs)
+	})
+
+	mux.HandleFunc("/oauth2/validate", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		token := r.Header.Get("Authorization")[len("OAuth "):]
+		if token != f.appToken && token != f.Moderator {
+			writeHelixError(w, http.StatusUnauthorized, "invalid access token")
+			return
+		}
+
+		writeJSON(w, validateResponse{ExpiresIn: 3600})
+	})
+
+	mux.HandleFunc("/channels/followers", func(w http.ResponseWriter, r *http.Request) {
+		f.count()
+		if r.Header.Get("Authorization") != "Bearer "+f.currentModerator() {
+			writeHelixError(w, http.StatusUnauthorized, "Invalid OAuth token")
+			return
+		}
+		if r.FormValue("broadcaster_id") != f.BroadcasterID {
+			writeHelixError(w, http.StatusBadRequest, "wrong broadcaster_id")
+			return
+		}
+
+		f.mu.Lock()
+		followers := f.Followers
+		f.mu.Unlock()
+
+		rs := followersResponse{Total: len(followers), Data: []Follower{}}
+
+		if userID := r.FormValue("user_id"); userID != "" {
+			for _, flw := range followers {
+				if flw.UserID == userID {
+					rs.Data = append(rs.Data, flw)
+				}
+			}
+			writeJSON(w, rs)
+			return
+		}
+
+		first, _ := strconv.Atoi(r.FormValue("first"))
+		if first <= 0 {
+			first = 20
+		}
+		offset, _ := strconv.Atoi(r.FormValue("after"))
+
+		end := offset + first
+		if end >= len(followers) {
+			end = len(followers)
+		} else {
+			rs.Pagination.Cursor = strconv.Itoa(end)
+		}
+		rs.Data = append(rs.Data, followers[offset:end]...)
+
+		writeJSON(w, rs)
+	})
+
+	mux.HandleFunc("/subscriptions", f.channelUsers(func(userID string) (string, bool) {
+		tier, found := f.Subs[userID]
+		return tier, found
+	}))
+	mux.HandleFunc("/channels/vips", f.channelUsers(func(userID string) (string, bool) {
+		return "", contains(f.VIPs, userID)
+	}))
+	mux.HandleFunc("/moderation/moderators", f.channelUsers(func(userID string) (string, bool) {
+		return "", contains(f.Mods, userID)
+	}))
+
+	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		f.count()
+		userID, found := f.userByToken(r)
+		if !found {
+			writeHelixError(w, http.StatusUnauthorized, "Invalid OAuth token")
+			return
+		}
+		if login := r.FormValue("login"); login != "" {
+			userID = login
+			if login == f.Channel {
+				userID = f.BroadcasterID
+			}
+		}
+
+		// slow response, to overlap concurrent callbacks
+		time.Sleep(5 * time.Millisecond)
+
+		writeJSON(w, usersResponse{Data: []helix.User{{
+			ID:          userID,
+			Login:       "user" + userID,
+			DisplayName: "User" + userID,
+		}}})
+	})
+
+	mux.HandleFunc("/channels/followed", func(w http.ResponseWriter, r *http.Request) {
+		f.count()
+		userID, found := f.userByToken(r)
+		if !found || userID != r.FormValue("user_id") {
+			writeHelixError(w, http.StatusUnauthorized, "Invalid OAuth token")
+			return
+		}
+
+		f.mu.Lock()
+		followers := f.Followers
+		f.mu.Unlock()
+
+		rs := followedResponse{Data: []followedChannel{}}
+		for _, flw := range followers {
+			if flw.UserID == userID && r.FormValue("broadcaster_id") == f.BroadcasterID {
+				rs.Data = append(rs.Data, followedChannel{BroadcasterID: f.BroadcasterID})
+			}
+		}
+		rs.Total = len(rs.Data)
+
+		writeJSON(w, rs)
+	})
+
+	return mux
+}
+
+// channelUsers handler of subscriptions, vips and moderators endpoints filtered by user_id,
+// match return tier of user and is user in list, called under lock
+func (f *Helix) channelUsers(match func(userID string) (string, bool)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f.count()
+		if r.Header.Get("Authorization") != "Bearer "+f.currentModerator() {
+			writeHelixError(w, http.StatusUnauthorized, "Invalid OAuth token")
+			return
+		}
+		if r.FormValue("broadcaster_id") != f.BroadcasterID {
+			writeHelixError(w, http.StatusBadRequest, "wrong broadcaster_id")
+			return
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		var users []channelUser
+		for _, userID := range r.Form["user_id"] {
+			if tier, found := match(userID); found {
+				users = append(users, channelUser{UserID: userID, UserLogin: "user" + userID, Tier: tier})
+			}
+		}
+
+		// paged as helix, 20 users by default
+		first, _ := strconv.Atoi(r.FormValue("first"))
+		if first <= 0 {
+			first = 20
+		}
+		offset, _ := strconv.Atoi(r.FormValue("after"))
+
+		rs := channelUsersResponse{Data: []channelUser{}}
+		end := offset + first
+		if end >= len(users) {
+			end = len(users)
+		} else {
+			rs.Pagination.Cursor = strconv.Itoa(end)
+		}
+		if offset < end {
+			rs.Data = append(rs.Data, users[offset:end]...)
+		}
+
+		writeJSON(w, rs)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// userByToken find user id by bearer token, app token is valid for any user
+func (f *Helix) userByToken(r *http.Request) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == f.appToken && token != "" {
+		return "", true
+	}
+	userID, found := f.Users[token]
+
+	return userID, found
+}
+
+// Unfollow remove user from channel followers
+func (f *Helix) Unfollow(userID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	followers := make([]Follower, 0, len(f.Followers))
+	for _, flw := range f.Followers {
+		if flw.UserID != userID {
+			followers = append(followers, flw)
+		}
+	}
+	f.Followers = followers
+}
+
+// SetMods replace channel moderators
+func (f *Helix) SetMods(userIDs ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Mods = userIDs
+}
+
+// SetModerator replace broadcaster token, as if twitch revoked old one
+func (f *Helix) SetModerator(token string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Moderator = token
+}
+
+// RevokeAppToken invalidate issued app token
+func (f *Helix) RevokeAppToken() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.appToken = ""
+}
+
+func (f *Helix) currentModerator() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Moderator
+}
+
+// TokensIssued count of tokens issued by oauth2 endpoint
+func (f *Helix) TokensIssued() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.issued
+}
+
+func (f *Helix) count() {
+	f.mu.Lock()
+	f.requests++
+	f.mu.Unlock()
+}
+
+// ResetCount return helix requests count since last reset
+func (f *Helix) ResetCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := f.requests
+	f.requests = 0
+	return n
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeHelixError(w http.ResponseWriter, status int, msg string) {
+	w.WriteHeader(status)
+	writeJSON(w, helixError{Status: status, Err: http.StatusText(status), Message: msg})
+}
+
+// NewFollowers followers with ids 1..n and logins user1..userN
+func NewFollowers(n int) []Follower {
+	rs := make([]Follower, 0, n)
+	for i := 1; i <= n; i++ {
+		rs = append(rs, Follower{
+			UserID:     strconv.Itoa(i),
+			UserLogin:  fmt.Sprintf("user%d", i),
+			FollowedAt: time.Now(),
+		})
+	}
+	return rs
+}
@@ -0,0 +1,255 @@
+package fake
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Patreon local patreon server with oauth2 token, identity and campaign members endpoints
+type Patreon struct {
+	CampaignID string
+	// Creator current creator token, refresh token is always "creator-refresh"
+	Creator string
+	Members []PatreonMember
+	// authorization code -> user id, creator id is "1"
+	Codes map[string]string
+
+	mu       sync.Mutex
+	users    map[string]string
+	requests int
+	issued   int
+}
+
+// PatreonMember campaign member
+type PatreonMember struct {
+	UserID   string
+	FullName string
+	// Status active_patron, declined_patron or former_patron
+	Status string
+	Cents  int
+}
+
+type patreonResource struct {
+	ID            string                 `json:"id"`
+	Type          string                 `json:"type"`
+	Attributes    map[string]interface{} `json:"attributes"`
+	Relationships map[string]interface{} `json:"relationships,omitempty"`
+}
+
+// Handler serve oauth2 token on /api/oauth2/token and api on /api/oauth2/v2
+func (f *Patreon) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if f.users == nil {
+			f.users = make(map[string]string)
+		}
+		f.issued++
+
+		rs := tokenResponse{ExpiresIn: 2678400}
+		scope := "identity identity.memberships"
+
+		switch r.FormValue("grant_type") {
+		case "authorization_code":
+			userID, found := f.Codes[r.FormValue("code")]
+			if !found {
+				writePatreonError(w, http.StatusBadRequest, "invalid_grant")
+				return
+			}
+			if userID == "1" {
+				f.Creator = fmt.Sprintf("creator-token-%d", f.issued)
+				rs.AccessToken = f.Creator
+				rs.RefreshToken = "creator-refresh"
+				scope = "identity campaigns campaigns.members"
+				break
+			}
+			rs.AccessToken = fmt.Sprintf("patreon-token-%s", userID)
+			f.users[rs.AccessToken] = userID
+		case "refresh_token":
+			if r.FormValue("refresh_token") != "creator-refresh" {
+				writePatreonError(w, http.StatusBadRequest, "invalid_grant")
+				return
+			}
+			f.Creator = fmt.Sprintf("creator-token-%d", f.issued)
+			rs.AccessToken = f.Creator
+			rs.RefreshToken = "creator-refresh"
+			scope = "identity campaigns campaigns.members"
+		default:
+			writePatreonError(w, http.StatusBadRequest, "unsupported_grant_type")
+			return
+		}
+
+		writeJSON(w, map[string]interface{}{
+			"access_token":  rs.AccessToken,
+			"refresh_token": rs.RefreshToken,
+			"expires_in":    rs.ExpiresIn,
+			"scope":         scope,
+			"token_type":    "Bearer",
+		})
+	})
+
+	mux.HandleFunc("/api/oauth2/v2/identity", func(w http.ResponseWriter, r *http.Request) {
+		f.count()
+		userID, found := f.userByToken(r)
+		if !found {
+			writePatreonError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		user := patreonResource{ID: userID, Type: "user", Attributes: map[string]interface{}{"full_name": "Creator"}}
+		var included []patreonResource
+		for _, m := range f.Members {
+			if m.UserID != userID {
+				continue
+			}
+			user.Attributes["full_name"] = m.FullName
+			included = append(included, f.member(m))
+		}
+
+		writeJSON(w, map[string]interface{}{"data": user, "included": included})
+	})
+
+	mux.HandleFunc("/api/oauth2/v2/campaigns/", func(w http.ResponseWriter, r *http.Request) {
+		f.count()
+		if r.Header.Get("Authorization") != "Bearer "+f.currentCreator() {
+			writePatreonError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		if r.URL.Path != "/api/oauth2/v2/campaigns/"+f.CampaignID+"/members" {
+			writePatreonError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		count, _ := strconv.Atoi(r.FormValue("page[count]"))
+		if count <= 0 {
+			count = 20
+		}
+		offset, _ := strconv.Atoi(r.FormValue("page[cursor]"))
+
+		end := offset + count
+		next := ""
+		if end >= len(f.Members) {
+			end = len(f.Members)
+		} else {
+			next = strconv.Itoa(end)
+		}
+
+		data := make([]patreonResource, 0, end-offset)
+		for _, m := range f.Members[offset:end] {
+			data = append(data, f.member(m))
+		}
+
+		writeJSON(w, map[string]interface{}{
+			"data": data,
+			"meta": map[string]interface{}{
+				"pagination": map[string]interface{}{
+					"total":   len(f.Members),
+					"cursors": map[string]interface{}{"next": next},
+				},
+			},
+		})
+	})
+
+	return mux
+}
+
+func (f *Patreon) member(m PatreonMember) patreonResource {
+	return patreonResource{
+		ID:   "member-" + m.UserID,
+		Type: "member",
+		Attributes: map[string]interface{}{
+			"full_name":                       m.FullName,
+			"patron_status":                   m.Status,
+			"currently_entitled_amount_cents": m.Cents,
+		},
+		Relationships: map[string]interface{}{
+			"user":     map[string]interface{}{"data": map[string]string{"id": m.UserID, "type": "user"}},
+			"campaign": map[string]interface{}{"data": map[string]string{"id": f.CampaignID, "type": "campaign"}},
+		},
+	}
+}
+
+// userByToken find user id by bearer token, creator token belong to user "1"
+func (f *Patreon) userByToken(r *http.Request) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == f.Creator && token != "" {
+		return "1", true
+	}
+	userID, found := f.users[token]
+
+	return userID, found
+}
+
+// SetStatus change patron status of member
+func (f *Patreon) SetStatus(userID, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range f.Members {
+		if f.Members[i].UserID == userID {
+			f.Members[i].Status = status
+		}
+	}
+}
+
+// TokensIssued count of tokens issued by oauth2 endpoint
+func (f *Patreon) TokensIssued() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.issued
+}
+
+// ResetCount return api requests count since last reset
+func (f *Patreon) ResetCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := f.requests
+	f.requests = 0
+	return n
+}
+
+func (f *Patreon) currentCreator() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Creator
+}
+
+func (f *Patreon) count() {
+	f.mu.Lock()
+	f.requests++
+	f.mu.Unlock()
+}
+
+// NewPatreonMembers active patrons with ids 101..100+n, pledge 500 cents, ids not clash with creator
+func NewPatreonMembers(n int) []PatreonMember {
+	rs := make([]PatreonMember, 0, n)
+	for i := 1; i <= n; i++ {
+		rs = append(rs, PatreonMember{
+			UserID:   strconv.Itoa(i + 100),
+			FullName: fmt.Sprintf("Patron %d", i+100),
+			Status:   "active_patron",
+			Cents:    500,
+		})
+	}
+	return rs
+}
+
+func writePatreonError(w http.ResponseWriter, status int, msg string) {
+	w.WriteHeader(status)
+	writeJSON(w, map[string]interface{}{"errors": []map[string]interface{}{{"status": strconv.Itoa(status), "title": msg}}})
+}
@@ -0,0 +1,442 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// Telegram local telegram bot api server, keep members rights and record bot requests
+type Telegram struct {
+	Token string
+	Group int64
+
+	mu      sync.Mutex
+	members map[int]*tb.ChatMember
+	files   map[string]string
+	calls   []Call
+	msgID   int
+	notify  chan Call
+
+	// updates queued for bot long polling
+	updates  []tb.Update
+	updateID int
+}
+
+// Call bot api request, params decoded from json or multipart form
+type Call struct {
+	Method string
+	Params map[string]interface{}
+}
+
+// Param return request parameter as string
+func (c Call) Param(name string) string {
+	return fmt.Sprint(c.Params[name])
+}
+
+// NewTelegram server for bot with token "bot-token" and group chat
+func NewTelegram(group int64) *Telegram {
+	return &Telegram{
+		Token:   "bot-token",
+		Group:   group,
+		members: make(map[int]*tb.ChatMember),
+		files:   make(map[string]string),
+		notify:  make(chan Call, 100),
+	}
+}
+
+// Handler serve bot api on /bot<token>/<method>
+func (f *Telegram) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path := strings.TrimPrefix(r.URL.Path, "/file/bot"+f.Token+"/"); path != r.URL.Path {
+			f.mu.Lock()
+			content, found := f.files[path]
+			f.mu.Unlock()
+			if !found {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write([]byte(content))
+			return
+		}
+
+		prefix := "/bot" + f.Token + "/"
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			writeTelegram(w, http.StatusUnauthorized, nil, "Unauthorized")
+			return
+		}
+
+		call := Call{Method: strings.TrimPrefix(r.URL.Path, prefix), Params: map[string]interface{}{}}
+		if call.Method == "getUpdates" {
+			writeTelegram(w, http.StatusOK, f.poll(), "")
+			return
+		}
+
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			if err := r.ParseMultipartForm(1 << 20); err == nil {
+				for k, v := range r.MultipartForm.Value {
+					call.Params[k] = v[0]
+				}
+				// uploaded files kept as content
+				for k, v := range r.MultipartForm.File {
+					if file, err := v[0].Open(); err == nil {
+						content, _ := io.ReadAll(file)
+						_ = file.Close()
+						call.Params[k] = string(content)
+					}
+				}
+			}
+		} else {
+			_ = json.NewDecoder(r.Body).Decode(&call.Params)
+		}
+
+		result, status, description := f.call(call)
+
+		f.mu.Lock()
+		f.calls = append(f.calls, call)
+		f.mu.Unlock()
+
+		select {
+		case f.notify <- call:
+		default:
+		}
+
+		writeTelegram(w, status, result, description)
+	})
+}
+
+func (f *Telegram) call(c Call) (interface{}, int, string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	userID, _ := strconv.Atoi(c.Param("user_id"))
+
+	switch c.Method {
+	case "getMe":
+		return tb.User{ID: 1, IsBot: true, FirstName: "ttg", Username: "ttg_bot"}, http.StatusOK, ""
+
+	case "getChat":
+		return tb.Chat{ID: f.Group, Type: tb.ChatSuperGroup, Title: "group"}, http.StatusOK, ""
+
+	case "getChatMember":
+		member, found := f.members[userID]
+		if !found {
+			return tb.ChatMember{User: &tb.User{ID: userID}, Role: tb.Left}, http.StatusOK, ""
+		}
+		return member, http.StatusOK, ""
+
+	case "getChatAdministrators":
+		var admins []tb.ChatMember
+		for _, member := range f.members {
+			if member.Role == tb.Administrator || member.Role == tb.Creator {
+				admins = append(admins, *member)
+			}
+		}
+		return admins, http.StatusOK, ""
+
+	case "getFile":
+		id := c.Param("file_id")
+		if _, found := f.files[id]; !found {
+			return nil, http.StatusBadRequest, "Bad Request: invalid file_id"
+		}
+		return tb.File{FileID: id, FilePath: id}, http.StatusOK, ""
+
+	case "restrictChatMember":
+		member, found := f.members[userID]
+		if !found {
+			return nil, http.StatusBadRequest, "Bad Request: user not found"
+		}
+		if member.Role == tb.Administrator {
+			return nil, http.StatusBadRequest, "Bad Request: user is an administrator of the chat"
+		}
+		member.CanSendMessages = c.Params["can_send_messages"] == true
+		member.CanSendMedia = c.Params["can_send_media_messages"] == true
+		member.CanSendPolls = c.Params["can_send_polls"] == true
+		member.CanSendOther = c.Params["can_send_other_messages"] == true
+		member.CanAddPreviews = c.Params["can_add_web_page_previews"] == true
+		// like telegram, less than 30 seconds or more than 366 days from now is forever
+		until, _ := strconv.ParseInt(c.Param("until_date"), 10, 64)
+		if until < time.Now().Add(30*time.Second).Unix() || until > time.Now().AddDate(0, 0, 366).Unix() {
+			until = 0
+		}
+		member.RestrictedUntil = until
+		if member.Role == tb.Member || member.Role == tb.Restricted {
+			member.Role = tb.Restricted
+		}
+		return true, http.StatusOK, ""
+
+	case "promoteChatMember":
+		member, found := f.members[userID]
+		if !found {
+			return nil, http.StatusBadRequest, "Bad Request: user not found"
+		}
+		// like telegram, bot can edit only admins it promoted
+		if member.Role == tb.Creator || (member.Role == tb.Administrator && !member.CanBeEdited) {
+			return nil, http.StatusBadRequest, "Bad Request: CHAT_ADMIN_REQUIRED"
+		}
+		admin := false
+		for _, right := range []string{"can_change_info", "can_delete_messages", "can_invite_users", "can_restrict_members",
+			"can_pin_messages", "can_promote_members", "can_manage_voice_chats", "can_manage_chat"} {
+			if c.Params[right] == true {
+				admin = true
+			}
+		}
+		if !admin {
+			member.Role = tb.Member
+			member.Title = ""
+			member.Rights = tb.NoRestrictions()
+			return true, http.StatusOK, ""
+		}
+		member.Role = tb.Administrator
+		member.CanBeEdited = true
+		member.CanDeleteMessages = c.Params["can_delete_messages"] == true
+		member.CanInviteUsers = c.Params["can_invite_users"] == true
+		member.CanPinMessages = c.Params["can_pin_messages"] == true
+		member.CanRestrictMembers = c.Params["can_restrict_members"] == true
+		return true, http.StatusOK, ""
+
+	case "setChatAdministratorCustomTitle":
+		member, found := f.members[userID]
+		if !found || member.Role != tb.Administrator {
+			return nil, http.StatusBadRequest, "Bad Request: user is not an administrator"
+		}
+		member.Title = c.Param("custom_title")
+		return true, http.StatusOK, ""
+
+	case "sendMessage", "sendDocument":
+		f.msgID++
+		chatID, _ := strconv.ParseInt(c.Param("chat_id"), 10, 64)
+		return tb.Message{
+			ID:       f.msgID,
+			Unixtime: time.Now().Unix(),
+			Chat:     &tb.Chat{ID: chatID, Type: tb.ChatPrivate},
+			Text:     c.Param("text"),
+		}, http.StatusOK, ""
+	}
+
+	return true, http.StatusOK, ""
+}
+
+// Push queue update, bot receive it by long polling
+func (f *Telegram) Push(upd tb.Update) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.updateID++
+	upd.ID = f.updateID
+	f.updates = append(f.updates, upd)
+}
+
+// poll return queued updates, wait a bit if there are none to not spin bot poller
+func (f *Telegram) poll() []tb.Update {
+	for i := 0; i < 10; i++ {
+		f.mu.Lock()
+		updates := f.updates
+		f.updates = nil
+		f.mu.Unlock()
+
+		if len(updates) > 0 {
+			return updates
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return []tb.Update{}
+}
+
+// Join add user to group as member without restrictions
+func (f *Telegram) Join(userID int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.members[userID] = &tb.ChatMember{
+		User:   &tb.User{ID: userID},
+		Role:   tb.Member,
+		Rights: tb.NoRestrictions(),
+	}
+}
+
+// Restrict mute member like chat admin does, zero until is forever
+func (f *Telegram) Restrict(userID int, until time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	member, found := f.members[userID]
+	if !found {
+		return
+	}
+	member.Role = tb.Restricted
+	member.Rights = tb.Rights{}
+	member.RestrictedUntil = 0
+	if !until.IsZero() {
+		member.RestrictedUntil = until.Unix()
+	}
+}
+
+// Leave member leave group, restrictions are kept like in supergroup
+func (f *Telegram) Leave(userID int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	member, found := f.members[userID]
+	if !found || member.Role == tb.Restricted {
+		return
+	}
+	member.Role = tb.Left
+	member.Rights = tb.NoRights()
+}
+
+// Admin make member group admin, like owner does, bot can not edit such admin
+func (f *Telegram) Admin(userID int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	member, found := f.members[userID]
+	if !found {
+		return
+	}
+	member.Role = tb.Administrator
+	member.CanBeEdited = false
+}
+
+// AddFile store file content, bot download it by file id
+func (f *Telegram) AddFile(fileID, content string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.files[fileID] = content
+}
+
+// Ban kick member from group like chat admin does
+func (f *Telegram) Ban(userID int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	member, found := f.members[userID]
+	if !found {
+		return
+	}
+	member.Role = tb.Kicked
+	member.Rights = tb.Rights{}
+}
+
+// Member return copy of member state
+func (f *Telegram) Member(userID int) tb.ChatMember {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if m, found := f.members[userID]; found {
+		return *m
+	}
+	return tb.ChatMember{User: &tb.User{ID: userID}, Role: tb.Left}
+}
+
+// Calls bot requests with method, in order of arrival
+func (f *Telegram) Calls(method string) []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var rs []Call
+	for _, c := range f.calls {
+		if c.Method == method {
+			rs = append(rs, c)
+		}
+	}
+	return rs
+}
+
+// Wait next bot request with method, fail after timeout
+func (f *Telegram) Wait(method string, timeout time.Duration) (Call, error) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case c := <-f.notify:
+			if c.Method == method {
+				return c, nil
+			}
+		case <-deadline:
+			return Call{}, fmt.Errorf("timeout waiting %s", method)
+		}
+	}
+}
+
+func writeTelegram(w http.ResponseWriter, status int, result interface{}, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	rs := map[string]interface{}{"ok": status == http.StatusOK}
+	if status == http.StatusOK {
+		rs["result"] = result
+	} else {
+		rs["error_code"] = status
+		rs["description"] = description
+	}
+
+	_ = json.NewEncoder(w).Encode(rs)
+}
+
+// PrivateMessage update with message from user to bot in private chat
+func PrivateMessage(userID int, text string) tb.Update {
+	return tb.Update{Message: &tb.Message{
+		ID:       1,
+		Unixtime: time.Now().Unix(),
+		Sender:   &tb.User{ID: userID, FirstName: "user"},
+		Chat:     &tb.Chat{ID: int64(userID), Type: tb.ChatPrivate},
+		Text:     text,
+	}}
+}
+
+// GroupMessage update with message from user in group
+func GroupMessage(group int64, userID int, text string) tb.Update {
+	return tb.Update{Message: &tb.Message{
+		ID:       1,
+		Unixtime: time.Now().Unix(),
+		Sender:   &tb.User{ID: userID, FirstName: "user"},
+		Chat:     &tb.Chat{ID: group, Type: tb.ChatSuperGroup},
+		Text:     text,
+	}}
+}
+
+// PrivateDocument update with document from user to bot in private chat
+func PrivateDocument(userID int, fileID, fileName, caption string) tb.Update {
+	return tb.Update{Message: &tb.Message{
+		ID:       1,
+		Unixtime: time.Now().Unix(),
+		Sender:   &tb.User{ID: userID, FirstName: "user"},
+		Chat:     &tb.Chat{ID: int64(userID), Type: tb.ChatPrivate},
+		Document: &tb.Document{File: tb.File{FileID: fileID}, FileName: fileName, MIME: "text/csv"},
+		Caption:  caption,
+	}}
+}
+
+// UserJoined update with service message about user joined group
+func UserJoined(group int64, userID int) tb.Update {
+	user := tb.User{ID: userID, FirstName: "user"}
+	return tb.Update{Message: &tb.Message{
+		ID:          1,
+		Unixtime:    time.Now().Unix(),
+		Sender:      &user,
+		Chat:        &tb.Chat{ID: group, Type: tb.ChatSuperGroup},
+		UserJoined:  &user,
+		UsersJoined: []tb.User{user},
+	}}
+}
+
+// UserLeft update with service message about user left group
+func UserLeft(group int64, userID int) tb.Update {
+	user := tb.User{ID: userID, FirstName: "user"}
+	return tb.Update{Message: &tb.Message{
+		ID:       1,
+		Unixtime: time.Now().Unix(),
+		Sender:   &user,
+		Chat:     &tb.Chat{ID: group, Type: tb.ChatSuperGroup},
+		UserLeft: &user,
+	}}
+}
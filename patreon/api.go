@@ -0,0 +1,179 @@
+package patreon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/leporel/ttg/storage"
+)
+
+// Raw oauth2 and api v2 requests, responses in JSON:API format
+
+type APIError struct {
+	Status int
+	Body   string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("patreon: %d: %s", e.Status, e.Body)
+}
+
+type relationship struct {
+	Data struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+	} `json:"data"`
+}
+
+type memberAttributes struct {
+	FullName                     string `json:"full_name"`
+	PatronStatus                 string `json:"patron_status"`
+	CurrentlyEntitledAmountCents int    `json:"currently_entitled_amount_cents"`
+}
+
+type member struct {
+	ID            string           `json:"id"`
+	Type          string           `json:"type"`
+	Attributes    memberAttributes `json:"attributes"`
+	Relationships struct {
+		User     relationship `json:"user"`
+		Campaign relationship `json:"campaign"`
+	} `json:"relationships"`
+}
+
+type identityResponse struct {
+	Data struct {
+		ID         string `json:"id"`
+		Attributes struct {
+			FullName string `json:"full_name"`
+		} `json:"attributes"`
+	} `json:"data"`
+	Included []member `json:"included"`
+}
+
+type membersResponse struct {
+	Data []member `json:"data"`
+	Meta struct {
+		Pagination struct {
+			Total   int `json:"total"`
+			Cursors struct {
+				Next string `json:"next"`
+			} `json:"cursors"`
+		} `json:"pagination"`
+	} `json:"meta"`
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	// Scopes separated by space
+	Scope string `json:"scope"`
+}
+
+// authorizationURL link to patreon authorization page, state returned to callback
+func (c *Client) authorizationURL(state string, scopes []string) string {
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {c.clientID},
+		"redirect_uri":  {c.redirectURI},
+		"scope":         {strings.Join(scopes, " ")},
+		"state":         {state},
+	}
+
+	return c.baseURL + "/oauth2/authorize?" + strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+// exchangeCode exchange authorization code from oauth callback to token
+func (c *Client) exchangeCode(code string) (*storage.Token, error) {
+	return c.authToken(url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {c.clientID},
+		"client_secret": {c.secret},
+		"redirect_uri":  {c.redirectURI},
+	})
+}
+
+func (c *Client) refreshToken(refreshToken string) (*storage.Token, error) {
+	return c.authToken(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"client_id":     {c.clientID},
+		"client_secret": {c.secret},
+	})
+}
+
+func (c *Client) authToken(form url.Values) (*storage.Token, error) {
+	resp, err := c.httpClient.Post(c.baseURL+"/api/oauth2/token", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, &APIError{Status: resp.StatusCode, Body: string(body)}
+	}
+
+	rs := &tokenResponse{}
+	if err := json.Unmarshal(body, rs); err != nil {
+		return nil, err
+	}
+
+	return &storage.Token{
+		AccessToken:  rs.AccessToken,
+		RefreshToken: rs.RefreshToken,
+		Scopes:       strings.Fields(rs.Scope),
+		ExpiresAt:    time.Now().Add(time.Duration(rs.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// identity user of token with his memberships
+func (c *Client) identity(token string) (*identityResponse, error) {
+	rs := &identityResponse{}
+
+	err := c.apiGet("/identity", url.Values{
+		"include":        {"memberships.campaign"},
+		"fields[user]":   {"full_name"},
+		"fields[member]": {"patron_status,currently_entitled_amount_cents"},
+	}, token, rs)
+	if err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+// apiGet request api v2 endpoint with token and decode response into rs
+func (c *Client) apiGet(path string, query url.Values, token string, rs interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+"/api/oauth2/v2"+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return &APIError{Status: resp.StatusCode, Body: string(body)}
+	}
+
+	return json.Unmarshal(body, rs)
+}
@@ -0,0 +1,287 @@
+// Package patreon check users are active patrons of campaign by patreon api v2
+package patreon
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leporel/ttg/core"
+	"github.com/leporel/ttg/storage"
+)
+
+// DefaultBaseURL patreon site, oauth2 and api urls are relative to it
+const DefaultBaseURL = "https://www.patreon.com"
+
+// tokenRefreshAhead creator token refreshed before expiry, patreon tokens live about a month
+const tokenRefreshAhead = 24 * time.Hour
+
+const membersPageSize = 1000
+
+// patronActive patron_status of member with paid pledge
+const patronActive = "active_patron"
+
+var _ core.IdentityProvider = (*Client)(nil)
+
+var ErrNotConnected = errors.New("creator not connected, owner must send /connect to bot")
+
+// Client check campaign members, creator token required for sweeps
+type Client struct {
+	clientID    string
+	secret      string
+	redirectURI string
+	campaignID  string
+	// Minimal pledge in cents, 0 for any active patron
+	minCents int
+
+	baseURL    string
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	creator *storage.Token
+	save    func(token *storage.Token) error
+}
+
+// NewClient create patreon client, baseURL empty for default
+func NewClient(campaignID, cliID, secret, host, baseURL string, minCents int) *Client {
+	schema := "https"
+
+	if host == "localhost" {
+		schema = "http"
+	}
+
+	c := &Client{
+		clientID:    cliID,
+		secret:      secret,
+		redirectURI: fmt.Sprintf("%s://%s:8444/auth/callback", schema, host),
+		campaignID:  campaignID,
+		minCents:    minCents,
+		baseURL:     DefaultBaseURL,
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+	if baseURL != "" {
+		c.baseURL = baseURL
+	}
+
+	return c
+}
+
+// Name of identity provider
+func (c *Client) Name() string {
+	return "Patreon"
+}
+
+// GetAuthLink oauth link for user to share his memberships, state returned to callback
+func (c *Client) GetAuthLink(state string) (string, error) {
+	return c.authorizationURL(state, []string{"identity", "identity.memberships"}), nil
+}
+
+// Authorize exchange callback code to user token and identity
+func (c *Client) Authorize(code string) (string, *core.Identity, error) {
+	token, err := c.exchangeCode(code)
+	if err != nil {
+		return "", nil, err
+	}
+
+	rs, err := c.identity(token.AccessToken)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token.AccessToken, &core.Identity{ID: rs.Data.ID, Name: rs.Data.Attributes.FullName}, nil
+}
+
+// Entitled user is active patron of campaign with enough pledge
+func (c *Client) Entitled(token string, _ *core.Identity) (bool, error) {
+	rs, err := c.identity(token)
+	if err != nil {
+		return false, err
+	}
+
+	for _, m := range rs.Included {
+		if m.Type == "member" && m.Relationships.Campaign.Data.ID == c.campaignID {
+			return c.entitled(m.Attributes), nil
+		}
+	}
+
+	return false, nil
+}
+
+func (c *Client) entitled(m memberAttributes) bool {
+	return m.PatronStatus == patronActive && m.CurrentlyEntitledAmountCents >= c.minCents
+}
+
+// GetEntitled return entitled patrons among userIDs and count of all entitled patrons,
+// patreon has no per user member lookup, so all members downloaded
+func (c *Client) GetEntitled(userIDs []string) (map[string]string, int, error) {
+	token, err := c.creatorToken()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	patrons := make(map[string]string)
+	cursor := ""
+
+	for {
+		query := url.Values{
+			"include":        {"user"},
+			"fields[member]": {"full_name,patron_status,currently_entitled_amount_cents"},
+			"page[count]":    {fmt.Sprint(membersPageSize)},
+		}
+		if cursor != "" {
+			query.Set("page[cursor]", cursor)
+		}
+
+		rs := &membersResponse{}
+		if err := c.apiGet("/campaigns/"+c.campaignID+"/members", query, token, rs); err != nil {
+			return nil, 0, err
+		}
+
+		for _, m := range rs.Data {
+			if c.entitled(m.Attributes) {
+				patrons[m.Relationships.User.Data.ID] = m.Attributes.FullName
+			}
+		}
+
+		cursor = rs.Meta.Pagination.Cursors.Next
+		if cursor == "" {
+			break
+		}
+	}
+
+	rs := make(map[string]string, len(userIDs))
+	for _, id := range userIDs {
+		if name, found := patrons[id]; found {
+			rs[id] = name
+		}
+	}
+
+	return rs, len(patrons), nil
+}
+
+// GetConnectLink auth link for creator, to give bot access to campaign members
+func (c *Client) GetConnectLink(state string) string {
+	return c.authorizationURL(state, []string{"identity", "campaigns", "campaigns.members"})
+}
+
+// Connect exchange creator code to token, token used for members endpoint
+func (c *Client) Connect(code string) (*storage.Token, error) {
+	token, err := c.exchangeCode(code)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := c.identity(token.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Name = storage.TokenBroadcaster
+	token.UserID = rs.Data.ID
+	token.Login = rs.Data.Attributes.FullName
+
+	if c.save != nil {
+		if err := c.save(token); err != nil {
+			return nil, err
+		}
+	}
+
+	c.SetCreatorToken(token)
+
+	return token, nil
+}
+
+// SetTokenSaver set func to store creator token after connect and every refresh
+func (c *Client) SetTokenSaver(save func(token *storage.Token) error) {
+	c.save = save
+}
+
+// SetCreatorToken use creator token for members endpoint, nil to disconnect
+func (c *Client) SetCreatorToken(token *storage.Token) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.creator = token
+}
+
+// CapabilitiesReport describe connected token
+func (c *Client) CapabilitiesReport() string {
+	c.mu.Lock()
+	token := c.creator
+	c.mu.Unlock()
+
+	if token == nil {
+		return ErrNotConnected.Error()
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Patreon connected as %s\n", token.Login))
+
+	members := false
+	for _, scope := range token.Scopes {
+		if scope == "campaigns.members" {
+			members = true
+		}
+	}
+	if members {
+		sb.WriteString(fmt.Sprintf("+ members of campaign %s\n", c.campaignID))
+	} else {
+		sb.WriteString("- members: missing scope campaigns.members\n")
+	}
+	if c.minCents > 0 {
+		sb.WriteString(fmt.Sprintf("Minimal pledge %d cents\n", c.minCents))
+	}
+
+	return sb.String()
+}
+
+// ValidateTokens refresh creator token if it is about to expire
+func (c *Client) ValidateTokens() error {
+	c.mu.Lock()
+	connected := c.creator != nil
+	c.mu.Unlock()
+
+	if !connected {
+		return nil
+	}
+
+	_, err := c.creatorToken()
+	return err
+}
+
+// creatorToken return creator access token, refreshed ahead of expiry
+func (c *Client) creatorToken() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.creator == nil {
+		return "", ErrNotConnected
+	}
+	if time.Now().Add(tokenRefreshAhead).Before(c.creator.ExpiresAt) {
+		return c.creator.AccessToken, nil
+	}
+
+	token, err := c.refreshToken(c.creator.RefreshToken)
+	if err != nil {
+		return "", fmt.Errorf("refresh creator token: %w, owner must send /connect to bot again", err)
+	}
+	token.Name = c.creator.Name
+	token.UserID = c.creator.UserID
+	token.Login = c.creator.Login
+
+	if c.save != nil {
+		if err := c.save(token); err != nil {
+			return "", err
+		}
+	}
+	c.creator = token
+
+	log.Printf("Patreon creator token refreshed, expires at %s\n", token.ExpiresAt.Format(time.RFC3339))
+
+	return token.AccessToken, nil
+}
@@ -0,0 +1,131 @@
+package patreon
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/leporel/ttg/internal/fake"
+	"github.com/leporel/ttg/storage"
+)
+
+func newFakePatreon(t *testing.T, f *fake.Patreon, minCents int) *Client {
+	srv := httptest.NewServer(f.Handler())
+	t.Cleanup(srv.Close)
+
+	c := NewClient(f.CampaignID, "client", "secret", "localhost", srv.URL, minCents)
+	c.httpClient = srv.Client()
+	c.SetCreatorToken(&storage.Token{
+		Name:         storage.TokenBroadcaster,
+		AccessToken:  f.Creator,
+		RefreshToken: "creator-refresh",
+		Scopes:       []string{"identity", "campaigns", "campaigns.members"},
+		ExpiresAt:    time.Now().Add(30 * 24 * time.Hour),
+	})
+
+	return c
+}
+
+func TestPatreonEntitled(t *testing.T) {
+	f := &fake.Patreon{
+		CampaignID: "77",
+		Creator:    "creator-token",
+		Members: []fake.PatreonMember{
+			{UserID: "101", FullName: "Active", Status: "active_patron", Cents: 500},
+			{UserID: "102", FullName: "Declined", Status: "declined_patron", Cents: 500},
+			{UserID: "103", FullName: "Small", Status: "active_patron", Cents: 100},
+		},
+		Codes: map[string]string{"code-101": "101", "code-102": "102", "code-103": "103", "code-104": "104"},
+	}
+	c := newFakePatreon(t, f, 300)
+
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{"code-101", true},
+		{"code-102", false},
+		{"code-103", false},
+		{"code-104", false},
+	}
+
+	for _, tt := range tests {
+		token, user, err := c.Authorize(tt.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entitled, err := c.Entitled(token, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entitled != tt.want {
+			t.Errorf("%s: entitled %v, want %v", tt.code, entitled, tt.want)
+		}
+	}
+
+	if _, _, err := c.Authorize("wrong"); err == nil {
+		t.Fatal("expected error for wrong code")
+	}
+}
+
+func TestPatreonGetEntitled(t *testing.T) {
+	f := &fake.Patreon{
+		CampaignID: "77",
+		Creator:    "creator-token",
+		Members:    fake.NewPatreonMembers(2500),
+	}
+	f.Members[0].Status = "former_patron"
+	c := newFakePatreon(t, f, 0)
+
+	rs, total, err := c.GetEntitled([]string{"101", "102", "9999"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2499 {
+		t.Fatalf("expected 2499 active patrons, got %d", total)
+	}
+	if len(rs) != 1 || rs["102"] != "Patron 102" {
+		t.Fatalf("wrong entitled %v", rs)
+	}
+	if n := f.ResetCount(); n != 3 {
+		t.Fatalf("expected 3 pages, got %d requests", n)
+	}
+}
+
+func TestPatreonCreatorToken(t *testing.T) {
+	f := &fake.Patreon{CampaignID: "77", Creator: "creator-token", Codes: map[string]string{"code-creator": "1"}}
+	c := newFakePatreon(t, f, 0)
+
+	// token about to expire refreshed by validation
+	c.SetCreatorToken(&storage.Token{AccessToken: "creator-token", RefreshToken: "creator-refresh", ExpiresAt: time.Now().Add(time.Hour)})
+	if err := c.ValidateTokens(); err != nil {
+		t.Fatal(err)
+	}
+	if f.TokensIssued() != 1 {
+		t.Fatalf("expected one refresh, got %d", f.TokensIssued())
+	}
+	if _, _, err := c.GetEntitled(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	c.SetCreatorToken(nil)
+	if _, _, err := c.GetEntitled(nil); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("expected not connected error, got %v", err)
+	}
+
+	var saved *storage.Token
+	c.SetTokenSaver(func(token *storage.Token) error {
+		saved = token
+		return nil
+	})
+	token, err := c.Connect("code-creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved == nil || saved.UserID != "1" || token.Name != storage.TokenBroadcaster {
+		t.Fatalf("creator token not saved: %+v", saved)
+	}
+	t.Log(c.CapabilitiesReport())
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+type AuditAction string
+
+const (
+	AuditGrant     AuditAction = "grant"
+	AuditRestrict  AuditAction = "restrict"
+	AuditWhiteList AuditAction = "whitelist"
+	AuditLink      AuditAction = "link"
+	AuditUnlink    AuditAction = "unlink"
+	AuditWarn      AuditAction = "warn"
+	AuditRole      AuditAction = "role"
+	// AuditConflict rights not changed, user restricted by chat admin
+	AuditConflict AuditAction = "conflict"
+	AuditLeave    AuditAction = "leave"
+	// AuditForget user data deleted by his request, entry has no user ids
+	AuditForget AuditAction = "forget"
+)
+
+// ActorBot used as actor when action was made by bot itself (sweep, new member)
+const ActorBot = 0
+
+// ActorDeleted actor of entries made by user who deleted his data
+const ActorDeleted = -1
+
+// AuditEntry single record of rights change, never updated after insert,
+// except anonymisation of user who deleted his data (see Storage.ForgetUser)
+type AuditEntry struct {
+	ID         int
+	Action     AuditAction
+	TelegramID int
+	TwitchID   int
+	Actor      int
+	Reason     string
+	CreatedAt  time.Time
+}
+
+func (e *AuditEntry) String() string {
+	actor := fmt.Sprint(e.Actor)
+	switch e.Actor {
+	case ActorBot:
+		actor = "bot"
+	case ActorDeleted:
+		actor = "deleted user"
+	}
+
+	rs := fmt.Sprintf("%s %s", e.CreatedAt.Format("2006-01-02 15:04"), e.Action)
+	if e.TelegramID != 0 {
+		rs += fmt.Sprintf(" tg:%d", e.TelegramID)
+	}
+	if e.TwitchID != 0 {
+		rs += fmt.Sprintf(" tw:%d", e.TwitchID)
+	}
+	rs += fmt.Sprintf(" by %s", actor)
+	if e.Reason != "" {
+		rs += fmt.Sprintf(" (%s)", e.Reason)
+	}
+
+	return rs
+}
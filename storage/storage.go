@@ -0,0 +1,1098 @@
+// Package storage keep linked users, whitelist, members, leavers, languages, audit log, grace state and tokens in sqlite
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type Storage struct {
+	db *sql.DB
+}
+
+type User struct {
+	TelegramID int
+	TwitchID   int
+	Name       string
+	CreatedAt  time.Time
+}
+
+type WhiteListedUser struct {
+	TelegramID  int
+	Description string
+}
+
+// Grace linked user not found as follower in last sweeps
+type Grace struct {
+	TelegramID    int
+	Strikes       int
+	FirstFailedAt time.Time
+}
+
+// TokenBroadcaster name of broadcaster or moderator token
+const TokenBroadcaster = "broadcaster"
+
+// Token twitch user token stored by bot
+type Token struct {
+	Name         string
+	UserID       string
+	Login        string
+	AccessToken  string
+	RefreshToken string
+	Scopes       []string
+	ExpiresAt    time.Time
+}
+
+// Member telegram user seen by bot in group
+type Member struct {
+	TelegramID int
+	Username   string
+	SeenAt     time.Time
+}
+
+// UserData everything stored about telegram user, nil and zero fields are not stored
+type UserData struct {
+	TelegramID int
+	Link       *User
+	WhiteList  *WhiteListedUser
+	Member     *Member
+	Grace      *Grace
+	Role       string
+	Profile    string
+	// Language code of user telegram client
+	Language string
+	LeftAt   *time.Time
+	// Cooldowns time until account can't be linked again, by account "tg:<id>" or "tw:<id>"
+	Cooldowns map[string]time.Time
+	Audit     []AuditEntry
+	// AuditMentions entries of other users made by user or mentioning him in reason
+	AuditMentions []AuditEntry
+}
+
+// New open sqlite database by path, missing tables created
+func New(path string) (*Storage, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	tables, err := db.Query("select name FROM sqlite_schema WHERE type = ? AND name NOT LIKE ?", "table", "sqlite_%")
+	if err != nil {
+		return nil, err
+	}
+
+	var exist []string
+	for tables.Next() {
+		var name string
+		err = tables.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		exist = append(exist, name)
+	}
+	err = tables.Err()
+	if err != nil {
+		return nil, err
+	}
+	err = tables.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.Contains(strings.Join(exist, ","), "whitelist") {
+
+		sqlStmt := `
+		drop table if exists whitelist;
+		create table whitelist (tg_id integer not null primary key, description text);
+		delete from whitelist;
+		`
+		_, err = db.Exec(sqlStmt)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Println("New database created")
+	}
+
+	if !strings.Contains(strings.Join(exist, ","), "followers") {
+
+		sqlStmt := `
+		drop table if exists followers;
+		create table followers (tg_id integer not null primary key, twitch_id integer not null, name text not null, created_at timestamp not null);
+        CREATE INDEX idx_twitch_id  ON followers(twitch_id);
+		delete from followers;
+		`
+		_, err = db.Exec(sqlStmt)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Println("New database created")
+	}
+
+	if !strings.Contains(strings.Join(exist, ","), "members") {
+
+		sqlStmt := `
+		drop table if exists members;
+		create table members (tg_id integer not null primary key, username text not null default '', seen_at timestamp not null);
+        CREATE INDEX idx_username  ON members(username);
+		delete from members;
+		`
+		_, err = db.Exec(sqlStmt)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Println("New database created")
+	}
+
+	if !strings.Contains(strings.Join(exist, ","), "audit") {
+
+		sqlStmt := `
+		drop table if exists audit;
+		create table audit (id integer not null primary key autoincrement, action text not null, tg_id integer not null, twitch_id integer not null default 0, actor integer not null, reason text not null default '', created_at timestamp not null);
+        CREATE INDEX idx_audit_tg_id  ON audit(tg_id);
+		`
+		_, err = db.Exec(sqlStmt)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Println("New database created")
+	}
+
+	if !strings.Contains(strings.Join(exist, ","), "grace") {
+
+		sqlStmt := `
+		drop table if exists grace;
+		create table grace (tg_id integer not null primary key, strikes integer not null, first_failed_at timestamp not null);
+		delete from grace;
+		`
+		_, err = db.Exec(sqlStmt)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Println("New database created")
+	}
+
+	if !strings.Contains(strings.Join(exist, ","), "tokens") {
+
+		sqlStmt := `
+		drop table if exists tokens;
+		create table tokens (name text not null primary key, user_id text not null, login text not null, access_token text not null, refresh_token text not null, scopes text not null, expires_at timestamp not null);
+		delete from tokens;
+		`
+		_, err = db.Exec(sqlStmt)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Println("New database created")
+	}
+
+	if !strings.Contains(strings.Join(exist, ","), "roles") {
+
+		sqlStmt := `
+		drop table if exists roles;
+		create table roles (tg_id integer not null primary key, status text not null);
+		delete from roles;
+		`
+		_, err = db.Exec(sqlStmt)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Println("New database created")
+	}
+
+	if !strings.Contains(strings.Join(exist, ","), "profiles") {
+
+		sqlStmt := `
+		drop table if exists profiles;
+		create table profiles (tg_id integer not null primary key, name text not null);
+		delete from profiles;
+		`
+		_, err = db.Exec(sqlStmt)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Println("New database created")
+	}
+
+	if !strings.Contains(strings.Join(exist, ","), "leavers") {
+
+		sqlStmt := `
+		drop table if exists leavers;
+		create table leavers (tg_id integer not null primary key, left_at timestamp not null);
+		delete from leavers;
+		`
+		_, err = db.Exec(sqlStmt)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Println("New database created")
+	}
+
+	if !strings.Contains(strings.Join(exist, ","), "cooldowns") {
+
+		sqlStmt := `
+		drop table if exists cooldowns;
+		create table cooldowns (account text not null primary key, until timestamp not null);
+		delete from cooldowns;
+		`
+		_, err = db.Exec(sqlStmt)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Println("New database created")
+	}
+
+	if !strings.Contains(strings.Join(exist, ","), "languages") {
+
+		sqlStmt := `
+		drop table if exists languages;
+		create table languages (tg_id integer not null primary key, language text not null);
+		delete from languages;
+		`
+		_, err = db.Exec(sqlStmt)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Println("New database created")
+	}
+
+	return &Storage{
+		db: db,
+	}, nil
+}
+
+func (s *Storage) AddWhiteList(user *WhiteListedUser) error {
+	_, err := s.db.Exec("INSERT OR IGNORE into whitelist(tg_id, description) values(?, ?)",
+		user.TelegramID, user.Description)
+	if err != nil {
+		return err
+	}
+	row := s.db.QueryRow("select tg_id from whitelist where tg_id = ?", user.TelegramID)
+	if row.Err() != nil {
+		return err
+	}
+	var id int
+	err = row.Scan(&id)
+	if err != nil {
+		return err
+	}
+	if id != user.TelegramID {
+		return fmt.Errorf("row not inserted")
+	}
+
+	return nil
+}
+
+func (s *Storage) GetWhiteListedUser(tgID int) (*WhiteListedUser, error) {
+
+	row := s.db.QueryRow("select tg_id, description from whitelist where tg_id = ?", tgID)
+	if row.Err() != nil {
+		return nil, row.Err()
+	}
+	u := &WhiteListedUser{}
+	err := row.Scan(&u.TelegramID, &u.Description)
+	if err != nil {
+		return nil, err
+	}
+	if tgID != u.TelegramID {
+		return nil, sql.ErrNoRows
+	}
+
+	return u, nil
+}
+
+// DeleteWhiteListedUser sql.ErrNoRows if user not in whitelist
+func (s *Storage) DeleteWhiteListedUser(tgID int) error {
+
+	affect, err := s.db.Exec("delete from whitelist where tg_id=?", tgID)
+	if err != nil {
+		return err
+	}
+
+	afc, err := affect.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if afc == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (s *Storage) AddUser(user *User) error {
+	_, err := s.db.Exec("INSERT OR IGNORE into followers(tg_id, twitch_id, name, created_at) values(?, ?, ?, ?)",
+		user.TelegramID, user.TwitchID, user.Name, user.CreatedAt)
+	if err != nil {
+		return err
+	}
+	row := s.db.QueryRow("select tg_id from followers where tg_id = ?", user.TelegramID)
+	if row.Err() != nil {
+		return err
+	}
+	var id int
+	err = row.Scan(&id)
+	if err != nil {
+		return err
+	}
+	if id != user.TelegramID {
+		return fmt.Errorf("row not inserted")
+	}
+
+	return nil
+}
+
+// RelinkUser link user in one transaction, replaced links of his telegram or twitch account returned
+func (s *Storage) RelinkUser(user *User) ([]User, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("select tg_id, twitch_id, name, created_at from followers where tg_id = ? or twitch_id = ?",
+		user.TelegramID, user.TwitchID)
+	if err != nil {
+		return nil, err
+	}
+
+	var replaced []User
+	for rows.Next() {
+		u := User{}
+		err = rows.Scan(&u.TelegramID, &u.TwitchID, &u.Name, &u.CreatedAt)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		replaced = append(replaced, u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if err = rows.Close(); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("delete from followers where tg_id = ? or twitch_id = ?", user.TelegramID, user.TwitchID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec("INSERT into followers(tg_id, twitch_id, name, created_at) values(?, ?, ?, ?)",
+		user.TelegramID, user.TwitchID, user.Name, user.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return replaced, tx.Commit()
+}
+
+func (s *Storage) GetUserByTgId(tgID int) (*User, error) {
+
+	row := s.db.QueryRow("select tg_id, twitch_id, name, created_at from followers where tg_id = ?", tgID)
+	if row.Err() != nil {
+		return nil, row.Err()
+	}
+	u := &User{}
+	err := row.Scan(&u.TelegramID, &u.TwitchID, &u.Name, &u.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if tgID != u.TelegramID {
+		return nil, sql.ErrNoRows
+	}
+
+	return u, nil
+}
+
+func (s *Storage) GetUserByTwId(twID int) (*User, error) {
+
+	row := s.db.QueryRow("select tg_id, twitch_id, name, created_at from followers where twitch_id = ?", twID)
+	if row.Err() != nil {
+		return nil, row.Err()
+	}
+	u := &User{}
+	err := row.Scan(&u.TelegramID, &u.TwitchID, &u.Name, &u.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if twID != u.TwitchID {
+		return nil, sql.ErrNoRows
+	}
+
+	return u, nil
+}
+
+// DeleteUser unlink user, sql.ErrNoRows if already unlinked
+func (s *Storage) DeleteUser(tgID int) error {
+
+	affect, err := s.db.Exec("delete from followers where tg_id=?", tgID)
+	if err != nil {
+		return err
+	}
+
+	afc, err := affect.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if afc == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetUsers return map[twitchID]telegramID
+func (s *Storage) GetUsers() (map[string]int, error) {
+
+	rows, err := s.db.Query("SELECT tg_id, twitch_id FROM followers")
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string]int, 0)
+
+	var tw string
+	var tg int
+
+	for rows.Next() {
+		err = rows.Scan(&tg, &tw)
+		if err != nil {
+			return nil, err
+		}
+		users[tw] = tg
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	err = rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// SaveMember insert or update last seen member info
+func (s *Storage) SaveMember(member *Member) error {
+	_, err := s.db.Exec("INSERT into members(tg_id, username, seen_at) values(?, ?, ?) ON CONFLICT(tg_id) DO UPDATE SET username=excluded.username, seen_at=excluded.seen_at",
+		member.TelegramID, strings.ToLower(member.Username), member.SeenAt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetMemberByUsername search member by username, without '@' and case insensitive
+func (s *Storage) GetMemberByUsername(username string) (*Member, error) {
+	username = strings.ToLower(strings.TrimPrefix(username, "@"))
+	if username == "" {
+		return nil, sql.ErrNoRows
+	}
+
+	row := s.db.QueryRow("select tg_id, username, seen_at from members where username = ? order by seen_at desc limit 1", username)
+	if row.Err() != nil {
+		return nil, row.Err()
+	}
+	m := &Member{}
+	err := row.Scan(&m.TelegramID, &m.Username, &m.SeenAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// GetMembers return all members seen by bot, map[tgID]member
+func (s *Storage) GetMembers() (map[int]*Member, error) {
+
+	rows, err := s.db.Query("SELECT tg_id, username, seen_at FROM members")
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[int]*Member, 0)
+
+	for rows.Next() {
+		m := &Member{}
+		err = rows.Scan(&m.TelegramID, &m.Username, &m.SeenAt)
+		if err != nil {
+			return nil, err
+		}
+		members[m.TelegramID] = m
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	err = rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// AddAudit append entry to audit log
+func (s *Storage) AddAudit(entry *AuditEntry) error {
+	_, err := s.db.Exec("INSERT into audit(action, tg_id, twitch_id, actor, reason, created_at) values(?, ?, ?, ?, ?, ?)",
+		entry.Action, entry.TelegramID, entry.TwitchID, entry.Actor, entry.Reason, entry.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetAudit return audit entries newest first, tgID = 0 for all users, limit = 0 for no limit
+func (s *Storage) GetAudit(tgID int, limit int) ([]AuditEntry, error) {
+	query := "SELECT id, action, tg_id, twitch_id, actor, reason, created_at FROM audit"
+	var args []interface{}
+
+	if tgID != 0 {
+		query += " WHERE tg_id = ?"
+		args = append(args, tgID)
+	}
+	query += " ORDER BY id DESC"
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	return queryAudit(s.db, query, args...)
+}
+
+// querier sql.DB or sql.Tx
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryAudit select audit entries by query of all audit columns
+func queryAudit(q querier, query string, args ...interface{}) ([]AuditEntry, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []AuditEntry
+
+	for rows.Next() {
+		var e AuditEntry
+		err = rows.Scan(&e.ID, &e.Action, &e.TelegramID, &e.TwitchID, &e.Actor, &e.Reason, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	err = rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// SaveGrace insert or update user grace state
+func (s *Storage) SaveGrace(g *Grace) error {
+	_, err := s.db.Exec("INSERT into grace(tg_id, strikes, first_failed_at) values(?, ?, ?) ON CONFLICT(tg_id) DO UPDATE SET strikes=excluded.strikes, first_failed_at=excluded.first_failed_at",
+		g.TelegramID, g.Strikes, g.FirstFailedAt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteGrace remove user grace state, not fail if user has no grace
+func (s *Storage) DeleteGrace(tgID int) error {
+	_, err := s.db.Exec("delete from grace where tg_id=?", tgID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetGraces return map[telegramID]Grace
+func (s *Storage) GetGraces() (map[int]*Grace, error) {
+
+	rows, err := s.db.Query("SELECT tg_id, strikes, first_failed_at FROM grace")
+	if err != nil {
+		return nil, err
+	}
+	graces := make(map[int]*Grace, 0)
+
+	for rows.Next() {
+		g := &Grace{}
+		err = rows.Scan(&g.TelegramID, &g.Strikes, &g.FirstFailedAt)
+		if err != nil {
+			return nil, err
+		}
+		graces[g.TelegramID] = g
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	err = rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return graces, nil
+}
+
+// SaveRole insert or update status of role given to user in chat
+func (s *Storage) SaveRole(tgID int, status string) error {
+	_, err := s.db.Exec("INSERT OR REPLACE into roles(tg_id, status) values(?, ?)", tgID, status)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteRole remove user role, not fail if user has no role
+func (s *Storage) DeleteRole(tgID int) error {
+	_, err := s.db.Exec("delete from roles where tg_id=?", tgID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetRoles return map[telegramID]status
+func (s *Storage) GetRoles() (map[int]string, error) {
+	return s.namesByUser("SELECT tg_id, status FROM roles")
+}
+
+// SaveProfile insert or update name of restriction profile applied to user by bot
+func (s *Storage) SaveProfile(tgID int, name string) error {
+	_, err := s.db.Exec("INSERT OR REPLACE into profiles(tg_id, name) values(?, ?)", tgID, name)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetProfile return name of profile applied to user
+func (s *Storage) GetProfile(tgID int) (string, error) {
+	row := s.db.QueryRow("select name from profiles where tg_id = ?", tgID)
+	if row.Err() != nil {
+		return "", row.Err()
+	}
+	var name string
+	err := row.Scan(&name)
+	if err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// DeleteProfile remove user profile, not fail if user has no profile
+func (s *Storage) DeleteProfile(tgID int) error {
+	_, err := s.db.Exec("delete from profiles where tg_id=?", tgID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetProfiles return map[telegramID]profile name
+func (s *Storage) GetProfiles() (map[int]string, error) {
+	return s.namesByUser("SELECT tg_id, name FROM profiles")
+}
+
+// SaveLeaver insert or update time when user left group
+func (s *Storage) SaveLeaver(tgID int, leftAt time.Time) error {
+	_, err := s.db.Exec("INSERT OR REPLACE into leavers(tg_id, left_at) values(?, ?)", tgID, leftAt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteLeaver remove leaver, not fail if user not left group
+func (s *Storage) DeleteLeaver(tgID int) error {
+	_, err := s.db.Exec("delete from leavers where tg_id=?", tgID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetLeavers return map[telegramID]time when user left group
+func (s *Storage) GetLeavers() (map[int]time.Time, error) {
+
+	rows, err := s.db.Query("SELECT tg_id, left_at FROM leavers")
+	if err != nil {
+		return nil, err
+	}
+	leavers := make(map[int]time.Time, 0)
+
+	for rows.Next() {
+		var tgID int
+		var leftAt time.Time
+		err = rows.Scan(&tgID, &leftAt)
+		if err != nil {
+			return nil, err
+		}
+		leavers[tgID] = leftAt
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	err = rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return leavers, nil
+}
+
+// SaveCooldown insert or update time until account can't be linked again, account is "tg:<id>" or "tw:<id>"
+func (s *Storage) SaveCooldown(account string, until time.Time) error {
+	_, err := s.db.Exec("INSERT OR REPLACE into cooldowns(account, until) values(?, ?)", account, until)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetCooldown return time until account can't be linked again
+func (s *Storage) GetCooldown(account string) (time.Time, error) {
+	row := s.db.QueryRow("select until from cooldowns where account = ?", account)
+	if row.Err() != nil {
+		return time.Time{}, row.Err()
+	}
+	var until time.Time
+	err := row.Scan(&until)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return until, nil
+}
+
+// DeleteCooldown remove account cooldown, not fail if account has no cooldown
+func (s *Storage) DeleteCooldown(account string) error {
+	_, err := s.db.Exec("delete from cooldowns where account=?", account)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SaveLanguage insert or update language code of user telegram client
+func (s *Storage) SaveLanguage(tgID int, language string) error {
+	_, err := s.db.Exec("INSERT OR REPLACE into languages(tg_id, language) values(?, ?)", tgID, language)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetLanguage return language code of user telegram client
+func (s *Storage) GetLanguage(tgID int) (string, error) {
+	row := s.db.QueryRow("select language from languages where tg_id = ?", tgID)
+	if row.Err() != nil {
+		return "", row.Err()
+	}
+	var language string
+	err := row.Scan(&language)
+	if err != nil {
+		return "", err
+	}
+
+	return language, nil
+}
+
+// ExportUser collect everything stored about user
+func (s *Storage) ExportUser(tgID int) (*UserData, error) {
+	data := &UserData{
+		TelegramID: tgID,
+		Cooldowns:  make(map[string]time.Time),
+	}
+
+	user, err := s.GetUserByTgId(tgID)
+	switch {
+	case err == nil:
+		data.Link = user
+	case err != sql.ErrNoRows:
+		return nil, err
+	}
+
+	white, err := s.GetWhiteListedUser(tgID)
+	switch {
+	case err == nil:
+		data.WhiteList = white
+	case err != sql.ErrNoRows:
+		return nil, err
+	}
+
+	m := &Member{}
+	err = s.db.QueryRow("select tg_id, username, seen_at from members where tg_id = ?", tgID).Scan(&m.TelegramID, &m.Username, &m.SeenAt)
+	switch {
+	case err == nil:
+		data.Member = m
+	case err != sql.ErrNoRows:
+		return nil, err
+	}
+
+	g := &Grace{}
+	err = s.db.QueryRow("select tg_id, strikes, first_failed_at from grace where tg_id = ?", tgID).Scan(&g.TelegramID, &g.Strikes, &g.FirstFailedAt)
+	switch {
+	case err == nil:
+		data.Grace = g
+	case err != sql.ErrNoRows:
+		return nil, err
+	}
+
+	err = s.db.QueryRow("select status from roles where tg_id = ?", tgID).Scan(&data.Role)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	data.Profile, err = s.GetProfile(tgID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	data.Language, err = s.GetLanguage(tgID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	var leftAt time.Time
+	err = s.db.QueryRow("select left_at from leavers where tg_id = ?", tgID).Scan(&leftAt)
+	switch {
+	case err == nil:
+		data.LeftAt = &leftAt
+	case err != sql.ErrNoRows:
+		return nil, err
+	}
+
+	accounts := []string{fmt.Sprintf("tg:%d", tgID)}
+	if data.Link != nil {
+		accounts = append(accounts, fmt.Sprintf("tw:%d", data.Link.TwitchID))
+	}
+	for _, account := range accounts {
+		until, err := s.GetCooldown(account)
+		switch {
+		case err == nil:
+			data.Cooldowns[account] = until
+		case err != sql.ErrNoRows:
+			return nil, err
+		}
+	}
+
+	data.Audit, err = s.GetAudit(tgID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	data.AuditMentions, err = auditMentions(s.db, tgID)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// auditMention user id in audit reason, e.g. "relinked to tg:<id>"
+func auditMention(tgID int) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf(`tg:%d\b`, tgID))
+}
+
+// auditMentions entries of other users made by user or mentioning him in reason, newest first
+func auditMentions(q querier, tgID int) ([]AuditEntry, error) {
+	entries, err := queryAudit(q, "SELECT id, action, tg_id, twitch_id, actor, reason, created_at FROM audit "+
+		"WHERE tg_id != ? AND (actor = ? OR reason LIKE ?) ORDER BY id DESC", tgID, tgID, fmt.Sprintf("%%tg:%d%%", tgID))
+	if err != nil {
+		return nil, err
+	}
+
+	mention := auditMention(tgID)
+	rs := entries[:0]
+	for _, e := range entries {
+		if e.Actor == tgID || mention.MatchString(e.Reason) {
+			rs = append(rs, e)
+		}
+	}
+
+	return rs, nil
+}
+
+// userAccounts twitch ids user was linked to, by link and his own audit entries
+func userAccounts(q querier, tgID int) (map[int]bool, error) {
+	rows, err := q.Query("SELECT twitch_id FROM followers WHERE tg_id = ? "+
+		"UNION SELECT twitch_id FROM audit WHERE tg_id = ? AND twitch_id != 0", tgID, tgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rs := make(map[int]bool)
+	for rows.Next() {
+		var twID int
+		if err = rows.Scan(&twID); err != nil {
+			return nil, err
+		}
+		rs[twID] = true
+	}
+
+	return rs, rows.Err()
+}
+
+// ForgetUser delete everything stored about user in one transaction, count of deleted audit entries
+// and anonymised entries of other users (made by user or mentioning him, his twitch ids cleared) returned.
+// Cooldowns kept until they expire, so link can't be moved by deletion
+func (s *Storage) ForgetUser(tgID int) (int, int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	accounts, err := userAccounts(tx, tgID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, table := range []string{"followers", "whitelist", "members", "grace", "roles", "profiles", "leavers", "languages"} {
+		_, err = tx.Exec("delete from "+table+" where tg_id = ?", tgID)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	affect, err := tx.Exec("delete from audit where tg_id = ?", tgID)
+	if err != nil {
+		return 0, 0, err
+	}
+	deleted, err := affect.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	mentions, err := auditMentions(tx, tgID)
+	if err != nil {
+		return 0, 0, err
+	}
+	mention := auditMention(tgID)
+	for _, e := range mentions {
+		actor := e.Actor
+		if actor == tgID {
+			actor = ActorDeleted
+		}
+		twID := e.TwitchID
+		if accounts[twID] {
+			twID = 0
+		}
+		_, err = tx.Exec("update audit set actor = ?, twitch_id = ?, reason = ? where id = ?",
+			actor, twID, mention.ReplaceAllString(e.Reason, "tg:deleted"), e.ID)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return int(deleted), len(mentions), tx.Commit()
+}
+
+// namesByUser query tg_id and text column into map
+func (s *Storage) namesByUser(query string) (map[int]string, error) {
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	rs := make(map[int]string, 0)
+
+	for rows.Next() {
+		var tgID int
+		var name string
+		err = rows.Scan(&tgID, &name)
+		if err != nil {
+			return nil, err
+		}
+		rs[tgID] = name
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	err = rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+// SaveToken insert or replace token by name
+func (s *Storage) SaveToken(t *Token) error {
+	_, err := s.db.Exec("INSERT OR REPLACE into tokens(name, user_id, login, access_token, refresh_token, scopes, expires_at) values(?, ?, ?, ?, ?, ?, ?)",
+		t.Name, t.UserID, t.Login, t.AccessToken, t.RefreshToken, strings.Join(t.Scopes, " "), t.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Storage) GetToken(name string) (*Token, error) {
+
+	row := s.db.QueryRow("select name, user_id, login, access_token, refresh_token, scopes, expires_at from tokens where name = ?", name)
+	if row.Err() != nil {
+		return nil, row.Err()
+	}
+	t := &Token{}
+	var scopes string
+	err := row.Scan(&t.Name, &t.UserID, &t.Login, &t.AccessToken, &t.RefreshToken, &scopes, &t.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	t.Scopes = strings.Fields(scopes)
+
+	return t, nil
+}
@@ -0,0 +1,431 @@
+package storage
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestStorage(t *testing.T) {
+	_, err := New("db.sqlite")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageWhiteList(t *testing.T) {
+	db, err := New("db.sqlite")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.AddWhiteList(&WhiteListedUser{2231231, "old"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := db.GetWhiteListedUser(2231231)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log(user)
+
+	err = db.DeleteWhiteListedUser(2231231)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DeleteWhiteListedUser(2231231); err != sql.ErrNoRows {
+		t.Fatalf("missing user must give sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestStorageUser(t *testing.T) {
+	db, err := New("db.sqlite")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.AddUser(&User{
+		TelegramID: 34235324,
+		TwitchID:   12312,
+		Name:       "Arthas",
+		CreatedAt:  time.Now(),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := db.GetUserByTgId(34235324)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log(user)
+
+	err = db.DeleteUser(34235324)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DeleteUser(34235324); err != sql.ErrNoRows {
+		t.Fatalf("already deleted user must give sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestStorageGetUsers(t *testing.T) {
+	db, err := New("db.sqlite")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := db.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log(users)
+}
+
+func TestStorageMember(t *testing.T) {
+	db, err := New("db.sqlite")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.SaveMember(&Member{
+		TelegramID: 4512312,
+		Username:   "Arthas",
+		SeenAt:     time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	member, err := db.GetMemberByUsername("@arthas")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if member.TelegramID != 4512312 {
+		t.Fatalf("wrong member %v", member)
+	}
+
+	members, err := db.GetMembers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if members[4512312] == nil || members[4512312].Username != "arthas" {
+		t.Fatalf("wrong members %v", members)
+	}
+}
+
+func TestStorageAudit(t *testing.T) {
+	db, err := New("db.sqlite")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.AddAudit(&AuditEntry{
+		Action:     AuditWhiteList,
+		TelegramID: 7731123,
+		Actor:      1,
+		Reason:     "test",
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := db.GetAudit(7731123, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Action != AuditWhiteList {
+		t.Fatalf("wrong audit entries %v", entries)
+	}
+
+	t.Log(entries[0].String())
+}
+
+func TestStorageGrace(t *testing.T) {
+	db, err := New("db.sqlite")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.SaveGrace(&Grace{TelegramID: 9921, Strikes: 2, FirstFailedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	graces, err := db.GetGraces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, found := graces[9921]; !found || g.Strikes != 2 {
+		t.Fatalf("wrong grace %v", graces)
+	}
+
+	err = db.DeleteGrace(9921)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageRole(t *testing.T) {
+	db, err := New("db.sqlite")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = db.SaveRole(9922, "vip"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SaveRole(9922, "moderator"); err != nil {
+		t.Fatal(err)
+	}
+
+	roles, err := db.GetRoles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roles[9922] != "moderator" {
+		t.Fatalf("wrong roles %v", roles)
+	}
+
+	err = db.DeleteRole(9922)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageProfile(t *testing.T) {
+	db, err := New("db.sqlite")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = db.SaveProfile(9923, "text_only"); err != nil {
+		t.Fatal(err)
+	}
+
+	profiles, err := db.GetProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profiles[9923] != "text_only" {
+		t.Fatalf("wrong profiles %v", profiles)
+	}
+	if name, err := db.GetProfile(9923); err != nil || name != "text_only" {
+		t.Fatalf("wrong profile %q: %v", name, err)
+	}
+
+	err = db.DeleteProfile(9923)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageLeaver(t *testing.T) {
+	db, err := New("db.sqlite")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leftAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err = db.SaveLeaver(9924, leftAt); err != nil {
+		t.Fatal(err)
+	}
+
+	leavers, err := db.GetLeavers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !leavers[9924].Equal(leftAt) {
+		t.Fatalf("wrong leavers %v", leavers)
+	}
+
+	if err = db.DeleteLeaver(9924); err != nil {
+		t.Fatal(err)
+	}
+	leavers, err = db.GetLeavers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := leavers[9924]; found {
+		t.Fatal("leaver must be deleted")
+	}
+}
+
+func TestStorageRelink(t *testing.T) {
+	db, err := New("db.sqlite")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, u := range []*User{
+		{TelegramID: 9925, TwitchID: 8825, Name: "wrong", CreatedAt: time.Now()},
+		{TelegramID: 9926, TwitchID: 8826, Name: "right", CreatedAt: time.Now()},
+	} {
+		if err = db.AddUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 9925 relink to twitch account of 9926
+	replaced, err := db.RelinkUser(&User{TelegramID: 9925, TwitchID: 8826, Name: "right", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replaced) != 2 {
+		t.Fatalf("wrong replaced %v", replaced)
+	}
+
+	user, err := db.GetUserByTwId(8826)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.TelegramID != 9925 {
+		t.Fatalf("wrong relinked user %v", user)
+	}
+	if _, err = db.GetUserByTgId(9926); err != sql.ErrNoRows {
+		t.Fatalf("replaced user must be unlinked, got %v", err)
+	}
+	if _, err = db.GetUserByTwId(8825); err != sql.ErrNoRows {
+		t.Fatalf("replaced account must be unlinked, got %v", err)
+	}
+
+	if err = db.DeleteUser(9925); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageCooldown(t *testing.T) {
+	db, err := New("db.sqlite")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err = db.SaveCooldown("tg:9927", until); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.GetCooldown("tg:9927")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(until) {
+		t.Fatalf("wrong cooldown %v, want %v", got, until)
+	}
+
+	if err = db.DeleteCooldown("tg:9927"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.GetCooldown("tg:9927"); err != sql.ErrNoRows {
+		t.Fatalf("cooldown must be deleted, got %v", err)
+	}
+}
+
+func TestStorageForgetUser(t *testing.T) {
+	db, err := New("db.sqlite")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const tgID = 9928
+	if err = db.AddUser(&User{TelegramID: tgID, TwitchID: 8828, Name: "forget", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SaveMember(&Member{TelegramID: tgID, Username: "forget", SeenAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SaveProfile(tgID, "full"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SaveLanguage(tgID, "ru"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SaveCooldown("tw:8828", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AddAudit(&AuditEntry{Action: AuditLink, TelegramID: tgID, TwitchID: 8828, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	// entries of other users made by user or mentioning him, and mention of other id with same prefix
+	others := []AuditEntry{
+		{Action: AuditUnlink, TelegramID: 9929, TwitchID: 8828, Actor: tgID, Reason: "relinked to tg:9928"},
+		{Action: AuditWhiteList, TelegramID: 9930, TwitchID: 8830, Actor: 1, Reason: "friend of tg:9928, tg:99281"},
+		{Action: AuditWhiteList, TelegramID: 9931, Actor: 1, Reason: "friend of tg:99281"},
+	}
+	for i := range others {
+		others[i].CreatedAt = time.Now()
+		if err = db.AddAudit(&others[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := db.ExportUser(tgID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Link == nil || data.Link.TwitchID != 8828 || data.Member == nil || data.Profile != "full" || data.Language != "ru" ||
+		data.WhiteList != nil || data.LeftAt != nil || len(data.Audit) != 1 || len(data.AuditMentions) != 2 {
+		t.Fatalf("wrong export %+v", data)
+	}
+	if _, found := data.Cooldowns["tw:8828"]; !found {
+		t.Fatalf("cooldown of linked account must be exported, got %v", data.Cooldowns)
+	}
+
+	deleted, anonymised, err := db.ForgetUser(tgID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 || anonymised != 2 {
+		t.Fatalf("deleted %d and anonymised %d audit entries, want 1 and 2", deleted, anonymised)
+	}
+
+	want := map[int]AuditEntry{
+		// twitch account of user cleared, other accounts kept
+		9929: {Actor: ActorDeleted, Reason: "relinked to tg:deleted"},
+		9930: {TwitchID: 8830, Actor: 1, Reason: "friend of tg:deleted, tg:99281"},
+		9931: {Actor: 1, Reason: "friend of tg:99281"},
+	}
+	for id, w := range want {
+		entries, err := db.GetAudit(id, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].TwitchID != w.TwitchID || entries[0].Actor != w.Actor || entries[0].Reason != w.Reason {
+			t.Fatalf("tg %d: got %+v, want twitch %d actor %d reason %q", id, entries, w.TwitchID, w.Actor, w.Reason)
+		}
+	}
+
+	data, err = db.ExportUser(tgID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Link != nil || data.Member != nil || data.Profile != "" || data.Language != "" || len(data.Audit) != 0 || len(data.AuditMentions) != 0 {
+		t.Fatalf("user data must be deleted, got %+v", data)
+	}
+
+	if err = db.DeleteCooldown("tw:8828"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,78 @@
+package telegram
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/leporel/ttg/core"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// initMembers grandfather seen members, group admins and users from csv document if not nil
+func (bot *Bot) initMembers(m *tb.Message, doc *tb.Document) {
+	ids, err := bot.adminIDs()
+	if err != nil {
+		log.Println("ERROR: ", err)
+		bot.sendErr(m, err)
+		return
+	}
+
+	if doc != nil {
+		imported, err := bot.importIDs(doc)
+		if err != nil {
+			log.Println("ERROR: ", err)
+			bot.send(m.Sender, err.Error())
+			return
+		}
+		ids = append(ids, imported...)
+	}
+
+	response, err := bot.cb(core.CommandInit, core.Data{UserIDs: ids, Actor: m.Sender.ID})
+	if err != nil {
+		log.Println("ERROR: ", err)
+		bot.sendErr(m, err)
+		return
+	}
+
+	log.Println(response)
+	bot.send(m.Sender, response)
+}
+
+// adminIDs ids of group admins and owner, except bots
+func (bot *Bot) adminIDs() ([]int, error) {
+	chat, err := bot.tg.ChatByID(bot.group)
+	if err != nil {
+		return nil, err
+	}
+
+	admins, err := bot.tg.AdminsOf(chat)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for _, admin := range admins {
+		if admin.User == nil || admin.User.IsBot {
+			continue
+		}
+		ids = append(ids, admin.User.ID)
+	}
+
+	return ids, nil
+}
+
+// importIDs download csv document and read user ids from it
+func (bot *Bot) importIDs(doc *tb.Document) ([]int, error) {
+	r, err := bot.tg.GetFile(&doc.File)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	ids, err := core.ParseMemberIDs(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", doc.FileName, err)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,101 @@
+package telegram
+
+import (
+	"fmt"
+
+	"github.com/leporel/ttg/core"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+var _ core.RoleSetter = (*Bot)(nil)
+
+// maxTitleLength telegram limit of admin custom title
+const maxTitleLength = 16
+
+// adminRights telegram admin rights by names used in core.Role
+var adminRights = map[string]func(r *tb.Rights){
+	"change_info":        func(r *tb.Rights) { r.CanChangeInfo = true },
+	"delete_messages":    func(r *tb.Rights) { r.CanDeleteMessages = true },
+	"invite_users":       func(r *tb.Rights) { r.CanInviteUsers = true },
+	"restrict_members":   func(r *tb.Rights) { r.CanRestrictMembers = true },
+	"pin_messages":       func(r *tb.Rights) { r.CanPinMessages = true },
+	"promote_members":    func(r *tb.Rights) { r.CanPromoteMembers = true },
+	"manage_voice_chats": func(r *tb.Rights) { r.CanManageVoiceChats = true },
+	"manage_chat":        func(r *tb.Rights) { r.CanManageChat = true },
+}
+
+// ValidateRoles check roles rights are telegram admin rights and titles fit telegram limit
+func ValidateRoles(roles []core.Role) error {
+	for _, role := range roles {
+		if len([]rune(role.Title)) > maxTitleLength {
+			return fmt.Errorf("role %s: title longer than %d characters", role.Status, maxTitleLength)
+		}
+		for _, right := range role.Rights {
+			if _, found := adminRights[right]; !found {
+				return fmt.Errorf("role %s: unknown telegram admin right %q", role.Status, right)
+			}
+		}
+	}
+
+	return nil
+}
+
+// roleRights admin rights of role, telegram admin must have at least one right,
+// so title only role can invite users
+func roleRights(role *core.Role) tb.Rights {
+	rights := tb.NoRights()
+	for _, name := range role.Rights {
+		if set, found := adminRights[name]; found {
+			set(&rights)
+		}
+	}
+	if len(role.Rights) == 0 {
+		rights.CanInviteUsers = true
+	}
+
+	return rights
+}
+
+// SetRole promote user to limited admin with custom title, nil role demote user to member.
+// Bot must be admin with right to add new admins, it can edit only admins it promoted itself
+func (bot *Bot) SetRole(userID int, role *core.Role, owned *core.Role) error {
+	chat, err := bot.tg.ChatByID(bot.group)
+	if err != nil {
+		return err
+	}
+
+	member, err := bot.tg.ChatMemberOf(chat, &tb.User{
+		ID: userID,
+	})
+	if err != nil {
+		return err
+	}
+	switch member.Role {
+	case tb.Left, tb.Kicked:
+		return core.ErrNotMember
+	case tb.Creator:
+		return core.ErrChatAdmin
+	case tb.Administrator:
+		if owned == nil || !member.CanBeEdited {
+			return core.ErrChatAdmin
+		}
+	}
+
+	if role == nil {
+		member.Rights = tb.NoRights()
+		return bot.tg.Promote(chat, member)
+	}
+
+	member.Rights = roleRights(role)
+	if err = bot.tg.Promote(chat, member); err != nil {
+		return err
+	}
+
+	if role.Title != "" {
+		if err = bot.tg.SetAdminTitle(chat, member.User, role.Title); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,737 @@
+// Package telegram restrict and grant rights in telegram group, pass bot commands to core service
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leporel/ttg/core"
+	"github.com/leporel/ttg/storage"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+var (
+	errHiddenForward   = errors.New("user hides his account in forwarded messages, send me his ID or @username")
+	errUnknownUsername = errors.New("username not seen by bot, send me user ID or forward his message")
+)
+
+var _ core.ChatPlatform = (*Bot)(nil)
+var _ core.DeepLinker = (*Bot)(nil)
+
+// Bot telegram implementation of core.ChatPlatform
+type Bot struct {
+	tg *tb.Bot
+
+	token string
+	host  string
+	cb    core.Handler
+	group string
+	owner string
+
+	messages *core.Messages
+
+	waitingID bool
+}
+
+// NewBot create bot, apiURL is telegram bot api url, empty for default
+func NewBot(token, apiURL string, group int, owner int, host string) (*Bot, error) {
+	var err error
+
+	var poller tb.Poller = &tb.LongPoller{
+		Timeout: 10 * time.Second,
+	}
+
+	var webhook bool
+
+	// You can use telegram webhooks instead long pooling if you generate self signed certs
+	if _, err := os.Stat("cert.pem"); err == nil && host != "localhost" {
+		log.Println("tg webhook mode")
+
+		var whTLS *tb.WebhookTLS
+		endp := &tb.WebhookEndpoint{
+			PublicURL: fmt.Sprintf("https://%s", host),
+		}
+
+		whTLS = &tb.WebhookTLS{
+			Key:  "key.pem",
+			Cert: "cert.pem",
+		}
+		endp.Cert = "cert.pem"
+
+		poller = &tb.Webhook{
+			Listen:        ":8443",
+			Endpoint:      endp,
+			TLS:           whTLS,
+			HasCustomCert: true,
+		}
+
+		webhook = true
+		log.Printf("Telegram bot webhooks set on %s:8443 \n", endp.PublicURL)
+	}
+
+	b, err := tb.NewBot(tb.Settings{
+		URL:    apiURL,
+		Token:  token,
+		Poller: poller,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !webhook {
+		if err = b.RemoveWebhook(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Bot{
+		token:    token,
+		host:     host,
+		group:    fmt.Sprintf("%d", group),
+		owner:    fmt.Sprintf("%d", owner),
+		tg:       b,
+		messages: core.NewMessages(),
+	}, nil
+}
+
+// SetMessages replace built-in texts sent to users, same catalogue as in core.Config
+func (bot *Bot) SetMessages(messages *core.Messages) {
+	bot.messages = messages
+}
+
+// text message in language of user telegram client
+func (bot *Bot) text(user *tb.User, key core.Message, args core.Args) string {
+	return bot.messages.Text(bot.messages.Locale(user.LanguageCode), key, args)
+}
+
+// Name of chat platform
+func (bot *Bot) Name() string {
+	return "Telegram"
+}
+
+// DeepLink link opening chat with bot
+func (bot *Bot) DeepLink() string {
+	return "https://t.me/" + bot.tg.Me.Username
+}
+
+// Start handle bot commands by handler, block until Stop
+func (bot *Bot) Start(handler core.Handler) {
+	bot.cb = handler
+	bot.handle()
+
+	bot.tg.Start()
+}
+
+// Stop receiving updates
+func (bot *Bot) Stop() {
+	bot.tg.Stop()
+}
+
+// handle register bot commands and events handlers
+func (bot *Bot) handle() {
+	var err error
+
+	bot.tg.Handle("/getlink", func(m *tb.Message) {
+		if !m.Private() {
+			return
+		}
+
+		if bot.checkExist(m.Sender.ID) {
+			_, errC := bot.cb(core.CommandRegrant, core.Data{UserID: m.Sender.ID, Actor: m.Sender.ID, Reason: "already linked"})
+			if errC != nil {
+				log.Println("ERROR: ", errC)
+			}
+			bot.send(m.Sender, bot.text(m.Sender, core.MsgAlreadyLinked, nil))
+			return
+		}
+
+		response, errC := bot.cb(core.CommandGetLink, core.Data{UserID: m.Sender.ID, Language: m.Sender.LanguageCode})
+		if errC != nil {
+			bot.sendErr(m, errC)
+			return
+		}
+
+		bot.send(m.Sender, response, tb.ModeHTML, tb.NoPreview)
+	})
+
+	// Link other twitch account, previous link replaced after authorization
+	bot.tg.Handle("/relink", func(m *tb.Message) {
+		if !m.Private() {
+			return
+		}
+
+		response, errC := bot.cb(core.CommandRelink, core.Data{UserID: m.Sender.ID, Language: m.Sender.LanguageCode})
+		if errC != nil {
+			bot.sendErr(m, errC)
+			return
+		}
+
+		bot.send(m.Sender, response, tb.ModeHTML, tb.NoPreview)
+	})
+
+	// User unlink himself, owner unlink any user with /unlink ID
+	bot.tg.Handle("/unlink", func(m *tb.Message) {
+		if !m.Private() {
+			return
+		}
+
+		id := m.Sender.ID
+		if m.Sender.Recipient() == bot.owner && m.Payload != "" {
+			var errC error
+			id, errC = bot.targetID(m, m.Payload)
+			if errC != nil {
+				bot.send(m.Sender, errC.Error())
+				return
+			}
+		}
+
+		response, errC := bot.cb(core.CommandUnlink, core.Data{UserID: id, Actor: m.Sender.ID})
+		if errC != nil {
+			bot.sendErr(m, errC)
+			return
+		}
+
+		bot.send(m.Sender, response)
+	})
+
+	bot.tg.Handle("/mydata", func(m *tb.Message) {
+		if !m.Private() {
+			return
+		}
+
+		response, errC := bot.cb(core.CommandMyData, core.Data{UserID: m.Sender.ID, Language: m.Sender.LanguageCode})
+		if errC != nil {
+			bot.sendErr(m, errC)
+			return
+		}
+
+		bot.send(m.Sender, &tb.Document{
+			File:     tb.FromReader(strings.NewReader(response)),
+			MIME:     "application/json",
+			FileName: fmt.Sprintf("mydata_%s.json", time.Now().Format("20060102")),
+		})
+	})
+
+	bot.tg.Handle("/forgetme", func(m *tb.Message) {
+		if !m.Private() {
+			return
+		}
+
+		if strings.TrimSpace(m.Payload) != "confirm" {
+			bot.send(m.Sender, bot.text(m.Sender, core.MsgForgetConfirm, nil))
+			return
+		}
+
+		response, errC := bot.cb(core.CommandForgetMe, core.Data{UserID: m.Sender.ID})
+		if errC != nil {
+			bot.sendErr(m, errC)
+			return
+		}
+
+		bot.send(m.Sender, response)
+	})
+
+	bot.tg.Handle("/add", func(m *tb.Message) {
+		if !m.Private() {
+			return
+		}
+		if m.Sender.Recipient() != bot.owner {
+			return
+		}
+
+		bot.waitingID = true
+		bot.send(m.Sender, "Send me user ID, @username or forward me his message")
+	})
+
+	// Owner can reply in group to user message, or pass ID/@username in private
+	bot.tg.Handle("/whitelist", func(m *tb.Message) {
+		if m.Sender.Recipient() != bot.owner {
+			return
+		}
+
+		if m.Chat.Recipient() == bot.group {
+			if m.ReplyTo == nil || m.ReplyTo.Sender == nil {
+				bot.send(m.Sender, "Reply with /whitelist to user message in group")
+				return
+			}
+			bot.seen(m.ReplyTo.Sender)
+			bot.whitelist(m.Sender, m.ReplyTo.Sender.ID)
+			return
+		}
+
+		if !m.Private() {
+			return
+		}
+
+		id, err := bot.targetID(m, m.Payload)
+		if err != nil {
+			bot.send(m.Sender, err.Error())
+			return
+		}
+
+		bot.whitelist(m.Sender, id)
+	})
+
+	bot.tg.Handle(tb.OnText, func(m *tb.Message) {
+		if m.Chat.Recipient() == bot.group {
+			bot.seen(m.Sender)
+			return
+		}
+		if !m.Private() {
+			return
+		}
+		if m.Sender.Recipient() != bot.owner {
+			return
+		}
+		if !bot.waitingID {
+			return
+		}
+		bot.waitingID = false
+
+		id, err := bot.targetID(m, m.Text)
+		if err != nil {
+			bot.send(m.Sender, err.Error())
+			return
+		}
+
+		bot.whitelist(m.Sender, id)
+	})
+
+	// Members who write not text, to reconcile them too
+	for _, endpoint := range []string{tb.OnPhoto, tb.OnVideo, tb.OnAnimation, tb.OnSticker,
+		tb.OnVoice, tb.OnAudio, tb.OnVideoNote, tb.OnPoll, tb.OnLocation, tb.OnContact} {
+		bot.tg.Handle(endpoint, func(m *tb.Message) {
+			if m.Chat.Recipient() == bot.group {
+				bot.seen(m.Sender)
+			}
+		})
+	}
+
+	bot.tg.Handle(tb.OnUserLeft, func(m *tb.Message) {
+		if m.Chat.Recipient() != bot.group {
+			return
+		}
+		if m.UserLeft == nil || m.UserLeft.IsBot {
+			return
+		}
+
+		log.Printf("User [%v] left group\n", m.UserLeft.ID)
+
+		_, err := bot.cb(core.CommandLeftUser, core.Data{UserID: m.UserLeft.ID})
+		if err != nil {
+			log.Println("ERROR: ", err)
+		}
+	})
+
+	// Grandfather current members, csv of user ids can be sent as document with /init caption
+	bot.tg.Handle("/init", func(m *tb.Message) {
+		if !m.Private() {
+			return
+		}
+		if m.Sender.Recipient() != bot.owner {
+			return
+		}
+
+		bot.initMembers(m, nil)
+	})
+
+	bot.tg.Handle(tb.OnDocument, func(m *tb.Message) {
+		if m.Chat.Recipient() == bot.group {
+			bot.seen(m.Sender)
+			return
+		}
+		if !m.Private() || m.Sender.Recipient() != bot.owner {
+			return
+		}
+		if strings.TrimSpace(m.Caption) != "/init" {
+			return
+		}
+
+		bot.initMembers(m, m.Document)
+	})
+
+	// Update new user permissions
+	bot.tg.Handle(tb.OnUserJoined, func(m *tb.Message) {
+		if m.Chat.Recipient() != bot.group {
+			return
+		}
+		var ids []int
+		if m.UserJoined != nil {
+			if m.UserJoined.IsBot {
+				return
+			}
+			ids = append(ids, m.UserJoined.ID)
+		} else {
+			for _, user := range m.UsersJoined {
+				if user.IsBot {
+					continue
+				}
+				ids = append(ids, user.ID)
+			}
+		}
+
+		for _, id := range ids {
+			if bot.checkExist(id) {
+				// rights of linked user could be reset while he was away
+				_, err = bot.cb(core.CommandRegrant, core.Data{UserID: id, Actor: storage.ActorBot, Reason: "rejoined group"})
+				if err != nil {
+					log.Println("ERROR:", err)
+				}
+				continue
+			}
+
+			log.Printf("New user [%v], set restrict\n", id)
+
+			_, err = bot.cb(core.CommandRestrict, core.Data{UserID: id, Actor: storage.ActorBot, Reason: "joined group"})
+			if err != nil {
+				log.Println("ERROR:", err)
+				continue
+			}
+		}
+
+		// seen after restrict, otherwise new user reconciled as unknown member
+		for _, user := range m.UsersJoined {
+			bot.seen(&user)
+		}
+		if m.UserJoined != nil {
+			bot.seen(m.UserJoined)
+		}
+	})
+
+	// Last audit log entries, optionally filtered by user ID
+	bot.tg.Handle("/audit", func(m *tb.Message) {
+		if !m.Private() {
+			return
+		}
+		if m.Sender.Recipient() != bot.owner {
+			return
+		}
+
+		var id int
+		if m.Payload != "" {
+			var errC error
+			id, errC = bot.targetID(m, m.Payload)
+			if errC != nil {
+				bot.send(m.Sender, errC.Error())
+				return
+			}
+		}
+
+		response, errC := bot.cb(core.CommandAuditLog, core.Data{UserID: id})
+		if errC != nil {
+			bot.sendErr(m, errC)
+			return
+		}
+
+		bot.send(m.Sender, response)
+	})
+
+	// Broadcaster or moderator authorization, required to read channel followers
+	bot.tg.Handle("/connect", func(m *tb.Message) {
+		if !m.Private() {
+			return
+		}
+		if m.Sender.Recipient() != bot.owner {
+			return
+		}
+
+		response, errC := bot.cb(core.CommandConnect, core.Data{UserID: m.Sender.ID})
+		if errC != nil {
+			bot.sendErr(m, errC)
+			return
+		}
+
+		bot.send(m.Sender, response, tb.ModeMarkdownV2, tb.NoPreview)
+	})
+
+	// Connected broadcaster token and enabled features
+	bot.tg.Handle("/capabilities", func(m *tb.Message) {
+		if !m.Private() {
+			return
+		}
+		if m.Sender.Recipient() != bot.owner {
+			return
+		}
+
+		response, errC := bot.cb(core.CommandCapabilities, core.Data{})
+		if errC != nil {
+			bot.sendErr(m, errC)
+			return
+		}
+
+		bot.send(m.Sender, response)
+	})
+
+	// Proceed sweep aborted by circuit breaker
+	bot.tg.Handle("/confirmsweep", func(m *tb.Message) {
+		if !m.Private() {
+			return
+		}
+		if m.Sender.Recipient() != bot.owner {
+			return
+		}
+
+		response, errC := bot.cb(core.CommandConfirmSweep, core.Data{})
+		if errC != nil {
+			bot.sendErr(m, errC)
+			return
+		}
+
+		bot.send(m.Sender, response)
+	})
+
+	// Full audit log as csv file
+	bot.tg.Handle("/auditexport", func(m *tb.Message) {
+		if !m.Private() {
+			return
+		}
+		if m.Sender.Recipient() != bot.owner {
+			return
+		}
+
+		response, errC := bot.cb(core.CommandAuditExport, core.Data{})
+		if errC != nil {
+			bot.sendErr(m, errC)
+			return
+		}
+
+		bot.send(m.Sender, &tb.Document{
+			File:     tb.FromReader(strings.NewReader(response)),
+			MIME:     "text/csv",
+			FileName: fmt.Sprintf("audit_%s.csv", time.Now().Format("20060102")),
+		})
+	})
+
+	/*
+		// Check if group is ours
+		b.Handle(tb.OnAddedToGroup, func(m *tb.Message) {
+			panic("not implemented")
+		})
+
+		//Message in group
+
+		b.Handle(tb.OnText, func(m *tb.Message) {
+			b.Send(m.Sender, "Hello World!")
+		})
+
+		b.Handle(tb.OnAudio, func(m *tb.Message) {
+			b.Send(m.Sender, "Hello World!")
+		})
+
+		b.Handle(tb.OnAnimation, func(m *tb.Message) {
+			b.Send(m.Sender, "Hello World!")
+		})
+
+		b.Handle(tb.OnVideo, func(m *tb.Message) {
+			b.Send(m.Sender, "Hello World!")
+		})
+
+		b.Handle(tb.OnSticker, func(m *tb.Message) {
+			b.Send(m.Sender, "Hello World!")
+		})
+
+		b.Handle(tb.OnLocation, func(m *tb.Message) {
+			b.Send(m.Sender, "Hello World!")
+		})
+
+		b.Handle(tb.OnContact, func(m *tb.Message) {
+			b.Send(m.Sender, "Hello World!")
+		})
+
+		b.Handle(tb.OnVideoNote, func(m *tb.Message) {
+			b.Send(m.Sender, "Hello World!")
+		})
+
+		b.Handle(tb.OnDocument, func(m *tb.Message) {
+			b.Send(m.Sender, "Hello World!")
+		})
+
+		b.Handle(tb.OnPhoto, func(m *tb.Message) {
+			b.Send(m.Sender, "Hello World!")
+		})
+	*/
+}
+
+// targetID resolve user ID from forwarded message, numeric ID or @username
+func (bot *Bot) targetID(m *tb.Message, text string) (int, error) {
+	if m.IsForwarded() {
+		if m.OriginalSender == nil {
+			return 0, errHiddenForward
+		}
+		return m.OriginalSender.ID, nil
+	}
+
+	text = strings.TrimSpace(text)
+
+	if CheckNumericOnly(text) {
+		return strconv.Atoi(text)
+	}
+
+	if !strings.HasPrefix(text, "@") {
+		return 0, fmt.Errorf("ID must be a numeric or @username")
+	}
+
+	r, err := bot.cb(core.CommandResolveUsername, core.Data{Username: text})
+	if err != nil {
+		return 0, err
+	}
+	if r == "none" {
+		return 0, errUnknownUsername
+	}
+
+	return strconv.Atoi(r)
+}
+
+func (bot *Bot) whitelist(owner *tb.User, id int) {
+	chat, err := bot.tg.ChatByID(bot.group)
+	if err != nil {
+		bot.send(owner, err.Error())
+		return
+	}
+
+	// user must be in group
+	if _, err := bot.tg.ChatMemberOf(chat, &tb.User{
+		ID: id,
+	}); err != nil {
+		bot.send(owner, err.Error())
+		return
+	}
+
+	response, err := bot.cb(core.CommandAddWhiteList, core.Data{UserID: id, Actor: owner.ID})
+	if err != nil {
+		bot.send(owner, err.Error())
+		return
+	}
+
+	bot.send(owner, response)
+}
+
+// seen remember user and his username, to find him later by @username
+func (bot *Bot) seen(user *tb.User) {
+	if user == nil || user.IsBot {
+		return
+	}
+
+	_, err := bot.cb(core.CommandSeenUser, core.Data{UserID: user.ID, Username: user.Username, Language: user.LanguageCode})
+	if err != nil {
+		log.Println("ERROR: ", err)
+	}
+}
+
+func (bot *Bot) checkExist(id int) bool {
+	r, err := bot.cb(core.CommandCheckWhiteList, core.Data{UserID: id})
+	if err != nil {
+		log.Println("ERROR: ", err)
+	}
+	if r == "exist" {
+		return true
+	}
+	r, err = bot.cb(core.CommandCheckUser, core.Data{UserID: id})
+	if err != nil {
+		log.Println("ERROR: ", err)
+	}
+	if r == "exist" {
+		return true
+	}
+
+	return false
+}
+
+// SetRights apply profile permissions to user in group.
+// Banned users and restrictions not matching owned profile are set by admins, left as is, admins can't be restricted
+func (bot *Bot) SetRights(userID int, profile core.Profile, owned *core.Profile) error {
+
+	chat, err := bot.tg.ChatByID(bot.group)
+	if err != nil {
+		return err
+	}
+
+	member, err := bot.tg.ChatMemberOf(chat, &tb.User{
+		ID: userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	switch member.Role {
+	case tb.Administrator, tb.Creator:
+		return core.ErrChatAdmin
+	case tb.Left:
+		return core.ErrNotMember
+	case tb.Kicked:
+		return core.ErrManualRestriction
+	case tb.Restricted:
+		if !ownedBy(member, owned) {
+			return core.ErrManualRestriction
+		}
+	}
+
+	member.Rights.CanSendMessages = profile.Messages
+	member.Rights.CanSendMedia = profile.Media
+	member.Rights.CanSendPolls = profile.Polls
+	member.Rights.CanSendOther = profile.Other
+	member.Rights.CanAddPreviews = profile.Previews
+	// forever, bot restrictions are lifted only by bot
+	member.RestrictedUntil = 0
+
+	return bot.tg.Restrict(chat, member)
+}
+
+// ownedBy restriction of member set by bot with owned profile. Bot restrict forever, timed restrictions
+// are set by admins. Restriction is manual if bot never changed member rights
+func ownedBy(member *tb.ChatMember, owned *core.Profile) bool {
+	// telegram treat until date more than 366 days from now as forever
+	if member.RestrictedUntil != 0 && member.RestrictedUntil < time.Now().AddDate(0, 0, 366).Unix() {
+		return false
+	}
+
+	if owned == nil {
+		return false
+	}
+	if owned.Muted() {
+		return !member.CanSendMessages
+	}
+
+	return member.CanSendMessages == owned.Messages &&
+		member.CanSendMedia == owned.Media &&
+		member.CanSendPolls == owned.Polls &&
+		member.CanSendOther == owned.Other
+}
+
+func (bot *Bot) send(r tb.Recipient, msg interface{}, options ...interface{}) {
+	_, err := bot.tg.Send(r, msg, options...)
+	if err != nil {
+		log.Println("ERROR [SEND]: ", err)
+	}
+}
+
+// SendUser send private message to user by id
+func (bot *Bot) SendUser(userID int, msg interface{}, options ...interface{}) {
+	bot.send(&tb.User{ID: userID}, msg, options...)
+}
+
+// SendOwner send message to bot owner, if owner set
+func (bot *Bot) SendOwner(msg interface{}, options ...interface{}) {
+	id, err := strconv.ParseInt(bot.owner, 10, 64)
+	if err != nil || id == 0 {
+		return
+	}
+
+	bot.send(tb.ChatID(id), msg, options...)
+}
+
+func (bot *Bot) sendErr(m *tb.Message, err error) {
+	var ce *core.CooldownError
+
+	switch {
+	case errors.As(err, &ce):
+		bot.send(m.Sender, bot.text(m.Sender, core.MsgCooldown, core.Args{"Until": ce.Until.Format(time.RFC822)}))
+	default:
+		bot.send(m.Sender, bot.text(m.Sender, core.MsgError, nil))
+	}
+}
@@ -0,0 +1,270 @@
+package twitch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leporel/ttg/storage"
+	"github.com/nicklaw5/helix/v2"
+)
+
+// Raw helix and oauth2 requests for endpoints not supported by helix library
+
+// helixMaxRetries how many times request repeated after rate limit exceeded
+const helixMaxRetries = 3
+
+type HelixError struct {
+	Status  int    `json:"status"`
+	Err     string `json:"error"`
+	Message string `json:"message"`
+}
+
+func (e *HelixError) Error() string {
+	return fmt.Sprintf("helix: %d %s: %s", e.Status, e.Err, e.Message)
+}
+
+var errInvalidToken = errors.New("token is invalid")
+
+func isUnauthorized(err error) bool {
+	var he *HelixError
+	return errors.As(err, &he) && he.Status == http.StatusUnauthorized
+}
+
+type helixPagination struct {
+	Cursor string `json:"cursor"`
+}
+
+type channelFollower struct {
+	UserID     string    `json:"user_id"`
+	UserLogin  string    `json:"user_login"`
+	UserName   string    `json:"user_name"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+type followersResponse struct {
+	Total      int               `json:"total"`
+	Data       []channelFollower `json:"data"`
+	Pagination helixPagination   `json:"pagination"`
+}
+
+type followedChannel struct {
+	BroadcasterID    string    `json:"broadcaster_id"`
+	BroadcasterLogin string    `json:"broadcaster_login"`
+	BroadcasterName  string    `json:"broadcaster_name"`
+	FollowedAt       time.Time `json:"followed_at"`
+}
+
+type followedResponse struct {
+	Total      int               `json:"total"`
+	Data       []followedChannel `json:"data"`
+	Pagination helixPagination   `json:"pagination"`
+}
+
+type usersResponse struct {
+	Data []helix.User `json:"data"`
+}
+
+// channelUser item of subscriptions, vips and moderators responses
+type channelUser struct {
+	UserID    string `json:"user_id"`
+	UserLogin string `json:"user_login"`
+	// Tier of subscription: 1000, 2000 or 3000
+	Tier string `json:"tier"`
+}
+
+type channelUsersResponse struct {
+	Data       []channelUser   `json:"data"`
+	Pagination helixPagination `json:"pagination"`
+}
+
+type tokenResponse struct {
+	AccessToken  string   `json:"access_token"`
+	RefreshToken string   `json:"refresh_token"`
+	ExpiresIn    int      `json:"expires_in"`
+	Scopes       []string `json:"scope"`
+}
+
+type validateResponse struct {
+	ClientID  string   `json:"client_id"`
+	Login     string   `json:"login"`
+	UserID    string   `json:"user_id"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn int      `json:"expires_in"`
+}
+
+// requestAppToken client credentials token, without scopes, app token can not have them
+func (t *Client) requestAppToken() (*storage.Token, error) {
+	return t.authToken(url.Values{
+		"client_id":     {t.clientID},
+		"client_secret": {t.apiCode},
+		"grant_type":    {"client_credentials"},
+	})
+}
+
+// exchangeCode exchange authorization code from oauth callback to user token
+func (t *Client) exchangeCode(code string) (*storage.Token, error) {
+	return t.authToken(url.Values{
+		"client_id":     {t.clientID},
+		"client_secret": {t.apiCode},
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {t.redirectURI},
+	})
+}
+
+// authorizationURL link to twitch authorization page, state returned to callback
+func (t *Client) authorizationURL(state string, scopes []string, forceVerify bool) string {
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {t.clientID},
+		"redirect_uri":  {t.redirectURI},
+		"scope":         {strings.Join(scopes, " ")},
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	if forceVerify {
+		query.Set("force_verify", "true")
+	}
+
+	// twitch expects scopes separated by encoded space, not '+'
+	return t.authBaseURL + "/authorize?" + strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+// refreshUserToken exchange refresh token to new user token
+func (t *Client) refreshUserToken(refreshToken string) (*storage.Token, error) {
+	return t.authToken(url.Values{
+		"client_id":     {t.clientID},
+		"client_secret": {t.apiCode},
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+}
+
+func (t *Client) authToken(form url.Values) (*storage.Token, error) {
+	resp, err := t.httpClient.Post(t.authBaseURL+"/token", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		he := &HelixError{}
+		if err := json.Unmarshal(body, he); err != nil {
+			he.Message = string(body)
+		}
+		he.Status = resp.StatusCode
+		return nil, he
+	}
+
+	rs := &tokenResponse{}
+	if err := json.Unmarshal(body, rs); err != nil {
+		return nil, err
+	}
+
+	return &storage.Token{
+		AccessToken:  rs.AccessToken,
+		RefreshToken: rs.RefreshToken,
+		Scopes:       rs.Scopes,
+		ExpiresAt:    time.Now().Add(time.Duration(rs.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// validateToken return token remaining lifetime, errInvalidToken if twitch rejected it
+func (t *Client) validateToken(accessToken string) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodGet, t.authBaseURL+"/validate", nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "OAuth "+accessToken)
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return 0, errInvalidToken
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("validate token: status %d", resp.StatusCode)
+	}
+
+	rs := &validateResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(rs); err != nil {
+		return 0, err
+	}
+
+	return time.Duration(rs.ExpiresIn) * time.Second, nil
+}
+
+// helixGet request helix endpoint with token and decode response into rs
+func (t *Client) helixGet(path string, query url.Values, token string, rs interface{}) error {
+	u := t.apiBaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	for i := 0; ; i++ {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Client-Id", t.clientID)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := t.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && i < helixMaxRetries {
+			waitRateLimit(resp.Header.Get("Ratelimit-Reset"))
+			continue
+		}
+
+		if resp.StatusCode >= http.StatusBadRequest {
+			he := &HelixError{Status: resp.StatusCode}
+			if err := json.Unmarshal(body, he); err != nil {
+				he.Message = string(body)
+			}
+			he.Status = resp.StatusCode
+			return he
+		}
+
+		return json.Unmarshal(body, rs)
+	}
+}
+
+func waitRateLimit(reset string) {
+	reset64, err := strconv.ParseInt(reset, 10, 64)
+	if err != nil {
+		return
+	}
+
+	timeDiff := time.Duration(reset64 - time.Now().Unix())
+	if timeDiff > 0 {
+		log.Printf("Waiting on rate limit to pass before sending next request (%d seconds)\n", timeDiff)
+		time.Sleep(timeDiff * time.Second)
+	}
+}
@@ -0,0 +1,144 @@
+package twitch
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/leporel/ttg/storage"
+)
+
+// tokenRefreshAhead token refreshed before expiry, to not fail requests in flight
+const tokenRefreshAhead = 10 * time.Minute
+
+// tokenManager keep token valid: refresh ahead of expiry, on 401 and on failed validation,
+// safe for concurrent use
+type tokenManager struct {
+	mu    sync.Mutex
+	name  string
+	token *storage.Token
+
+	// refresh return new token, old is nil if token was never issued
+	refresh func(old *storage.Token) (*storage.Token, error)
+	// validate return remaining token lifetime, errInvalidToken if token revoked
+	validate func(accessToken string) (time.Duration, error)
+	// save store refreshed token, optional
+	save func(token *storage.Token) error
+}
+
+// get return access token, refreshed if missing or about to expire
+func (m *tokenManager) get() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token != nil && time.Now().Add(tokenRefreshAhead).Before(m.token.ExpiresAt) {
+		return m.token.AccessToken, nil
+	}
+
+	if err := m.refreshLocked(); err != nil {
+		return "", err
+	}
+
+	return m.token.AccessToken, nil
+}
+
+// forceRefresh refresh token rejected by twitch, if other request did not refresh it yet
+func (m *tokenManager) forceRefresh(rejected string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token != nil && m.token.AccessToken != rejected {
+		return m.token.AccessToken, nil
+	}
+
+	if err := m.refreshLocked(); err != nil {
+		return "", err
+	}
+
+	return m.token.AccessToken, nil
+}
+
+func (m *tokenManager) refreshLocked() error {
+	token, err := m.refresh(m.token)
+	if err != nil {
+		return fmt.Errorf("refresh %s token: %w", m.name, err)
+	}
+
+	if m.save != nil {
+		if err := m.save(token); err != nil {
+			return err
+		}
+	}
+	m.token = token
+
+	log.Printf("Twitch %s token refreshed, expires at %s\n", m.name, token.ExpiresAt.Format(time.RFC3339))
+
+	return nil
+}
+
+// check validate current token and refresh it if twitch rejected it
+func (m *tokenManager) check() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token == nil {
+		return nil
+	}
+
+	ttl, err := m.validate(m.token.AccessToken)
+	if err == errInvalidToken {
+		log.Printf("Twitch %s token is invalid, refresh\n", m.name)
+		return m.refreshLocked()
+	}
+	if err != nil {
+		return err
+	}
+
+	m.token.ExpiresAt = time.Now().Add(ttl)
+	if ttl < tokenRefreshAhead {
+		return m.refreshLocked()
+	}
+
+	return nil
+}
+
+// do call request with token, and repeat it once with refreshed token if twitch respond 401
+func (m *tokenManager) do(call func(accessToken string) error) error {
+	token, err := m.get()
+	if err != nil {
+		return err
+	}
+
+	err = call(token)
+	if !isUnauthorized(err) {
+		return err
+	}
+
+	token, err = m.forceRefresh(token)
+	if err != nil {
+		return err
+	}
+
+	return call(token)
+}
+
+func (m *tokenManager) set(token *storage.Token) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.token = token
+}
+
+// current return copy of token, nil if not issued
+func (m *tokenManager) current() *storage.Token {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token == nil {
+		return nil
+	}
+
+	token := *m.token
+	return &token
+}
@@ -0,0 +1,598 @@
+// Package twitch check users follow channel by helix api, keep app and broadcaster tokens valid
+package twitch
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/leporel/ttg/core"
+	"github.com/leporel/ttg/storage"
+	"github.com/nicklaw5/helix/v2"
+	"github.com/patrickmn/go-cache"
+)
+
+const followersPageSize = 100
+
+// usersPerRequest max user_id params in one helix request
+const usersPerRequest = 100
+
+// Capability helix feature enabled by scope of broadcaster token
+type Capability struct {
+	Name  string
+	Scope string
+	// Moderator token is not enough, only broadcaster self token
+	Broadcaster bool
+}
+
+const (
+	CapFollowers     = "followers"
+	CapSubscriptions = "subscriptions"
+	CapVIPs          = "vips"
+	CapModerators    = "moderators"
+)
+
+var capabilities = []Capability{
+	{Name: CapFollowers, Scope: "moderator:read:followers"},
+	{Name: CapSubscriptions, Scope: "channel:read:subscriptions", Broadcaster: true},
+	{Name: CapVIPs, Scope: "channel:read:vips", Broadcaster: true},
+	{Name: CapModerators, Scope: "moderation:read", Broadcaster: true},
+}
+
+var _ core.IdentityProvider = (*Client)(nil)
+var _ core.StatusProvider = (*Client)(nil)
+
+// subTiers subscription tier to status
+var subTiers = map[string]core.Status{
+	"1000": core.StatusSubscriber1,
+	"2000": core.StatusSubscriber2,
+	"3000": core.StatusSubscriber3,
+}
+
+var ErrNotConnected = errors.New("broadcaster not connected, owner must send /connect to bot")
+
+// followCacheTTL how long positive follow check is trusted, negative results never cached
+const followCacheTTL = time.Hour
+
+// Client pass tokens explicitly to each request, so user requests run concurrently
+type Client struct {
+	// App token for public helix endpoints
+	app *tokenManager
+
+	clientID    string
+	apiCode     string
+	host        string
+	redirectURI string
+
+	broadcasterID string
+
+	apiBaseURL  string
+	authBaseURL string
+	httpClient  *http.Client
+
+	// Broadcaster or moderator token, required for followers, subscriptions, VIPs and moderators endpoints
+	moderator *tokenManager
+
+	// twitchID -> login of checked followers
+	follows *cache.Cache
+
+	// founders twitch ids of channel founders given by broadcaster
+	founders map[string]bool
+}
+
+// NewClient create twitch client, apiURL and authURL are helix and oauth2 urls, empty for default
+func NewClient(channelName, cliID, code, host, apiURL, authURL string) (*Client, error) {
+	schema := "https"
+
+	if host == "localhost" {
+		schema = "http"
+	}
+
+	app := &Client{
+		clientID:    cliID,
+		apiCode:     code,
+		host:        host,
+		redirectURI: fmt.Sprintf("%s://%s:8444/auth/callback", schema, host),
+		follows:     cache.New(followCacheTTL, 10*time.Minute),
+		apiBaseURL:  helix.DefaultAPIBaseURL,
+		authBaseURL: helix.AuthBaseURL,
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+	if apiURL != "" {
+		app.apiBaseURL = apiURL
+	}
+	if authURL != "" {
+		app.authBaseURL = authURL
+	}
+	app.initTokens()
+
+	channelID := ""
+
+	users := &usersResponse{}
+	err := app.app.do(func(token string) error {
+		return app.helixGet("/users", url.Values{"login": {channelName}}, token, users)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(users.Data) == 0 {
+		return nil, fmt.Errorf("not found twitch channel %s", channelName)
+	}
+
+	channelID = users.Data[0].ID
+
+	log.Println("Twitch channel id: ", channelName, channelID)
+
+	app.broadcasterID = channelID
+
+	return app, nil
+}
+
+func (t *Client) initTokens() {
+	t.app = &tokenManager{
+		name: "app",
+		refresh: func(_ *storage.Token) (*storage.Token, error) {
+			return t.requestAppToken()
+		},
+		validate: t.validateToken,
+	}
+
+	t.moderator = &tokenManager{
+		name: storage.TokenBroadcaster,
+		refresh: func(old *storage.Token) (*storage.Token, error) {
+			if old == nil {
+				return nil, ErrNotConnected
+			}
+
+			token, err := t.refreshUserToken(old.RefreshToken)
+			if err != nil {
+				return nil, fmt.Errorf("%w, owner must send /connect to bot again", err)
+			}
+			token.Name = old.Name
+			token.UserID = old.UserID
+			token.Login = old.Login
+
+			return token, nil
+		},
+		validate: t.validateToken,
+	}
+}
+
+// ValidateTokens validate app and broadcaster tokens, twitch require it every hour
+func (t *Client) ValidateTokens() error {
+	if err := t.app.check(); err != nil {
+		return err
+	}
+
+	return t.moderator.check()
+}
+
+// GetAuthLink oauth link for user to approve follows read, uniqueID returned to callback as state
+func (t *Client) GetAuthLink(uniqueID string) (string, error) {
+	return t.authorizationURL(uniqueID, []string{"user:read:follows", "user:read:subscriptions"}, false), nil
+}
+
+// GetUserToken exchange callback code to user access token
+func (t *Client) GetUserToken(code string) (string, error) {
+	token, err := t.exchangeCode(code)
+	if err != nil {
+		return "", err
+	}
+
+	return token.AccessToken, nil
+}
+
+// GetUser user self information by his token
+func (t *Client) GetUser(token string) (*helix.User, error) {
+	resp := &usersResponse{}
+
+	err := t.helixGet("/users", nil, token, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Data) == 0 {
+		return nil, fmt.Errorf("twitch returned no user for token")
+	}
+
+	return &resp.Data[0], nil
+}
+
+// GetConnectLink auth link for broadcaster or moderator, to give bot access to channel followers,
+// subscriptions, VIPs and moderators
+func (t *Client) GetConnectLink(uniqueID string) string {
+	scopes := make([]string, 0, len(capabilities))
+	for _, c := range capabilities {
+		scopes = append(scopes, c.Scope)
+	}
+
+	return t.authorizationURL(uniqueID, scopes, true)
+}
+
+// Connect exchange broadcaster or moderator code to token, token used for followers endpoints
+func (t *Client) Connect(code string) (*storage.Token, error) {
+	token, err := t.exchangeCode(code)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := t.GetUser(token.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Name = storage.TokenBroadcaster
+	token.UserID = user.ID
+	token.Login = user.Login
+
+	if t.moderator.save != nil {
+		if err := t.moderator.save(token); err != nil {
+			return nil, err
+		}
+	}
+
+	t.SetModeratorToken(token)
+
+	return token, nil
+}
+
+// SetTokenSaver set func to store broadcaster token after connect and every refresh
+func (t *Client) SetTokenSaver(save func(token *storage.Token) error) {
+	t.moderator.save = save
+}
+
+// SetModeratorToken use broadcaster or moderator token for followers endpoints, nil to disconnect
+func (t *Client) SetModeratorToken(token *storage.Token) {
+	t.moderator.set(token)
+	t.follows.Flush()
+}
+
+// HasCapability check broadcaster token allow to use feature
+func (t *Client) HasCapability(name string) bool {
+	token := t.moderator.current()
+
+	for _, c := range capabilities {
+		if c.Name == name {
+			return c.enabled(token, t.broadcasterID)
+		}
+	}
+
+	return false
+}
+
+// SetFounders twitch ids of channel founders, helix has no founder badge, so they are listed by broadcaster
+func (t *Client) SetFounders(twitchIDs []string) {
+	t.founders = make(map[string]bool, len(twitchIDs))
+	for _, id := range twitchIDs {
+		t.founders[id] = true
+	}
+}
+
+// CapabilitiesReport describe connected token and enabled features
+func (t *Client) CapabilitiesReport() string {
+	token := t.moderator.current()
+	if token == nil {
+		return ErrNotConnected.Error()
+	}
+
+	role := "moderator"
+	if token.UserID == t.broadcasterID {
+		role = "broadcaster"
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Twitch connected as %s (%s)\n", token.Login, role))
+	for _, c := range capabilities {
+		switch {
+		case c.enabled(token, t.broadcasterID):
+			sb.WriteString(fmt.Sprintf("+ %s\n", c.Name))
+		case c.Broadcaster && token.UserID != t.broadcasterID:
+			sb.WriteString(fmt.Sprintf("- %s: only broadcaster can connect it\n", c.Name))
+		default:
+			sb.WriteString(fmt.Sprintf("- %s: missing scope %s\n", c.Name, c.Scope))
+		}
+	}
+
+	return sb.String()
+}
+
+func (c Capability) enabled(token *storage.Token, broadcasterID string) bool {
+	if token == nil {
+		return false
+	}
+	if c.Broadcaster && token.UserID != broadcasterID {
+		return false
+	}
+	for _, scope := range token.Scopes {
+		if scope == c.Scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Name of identity provider
+func (t *Client) Name() string {
+	return "Twitch"
+}
+
+// Authorize exchange callback code to user token and identity
+func (t *Client) Authorize(code string) (string, *core.Identity, error) {
+	token, err := t.GetUserToken(code)
+	if err != nil {
+		return "", nil, err
+	}
+
+	user, err := t.GetUser(token)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, &core.Identity{ID: user.ID, Name: user.DisplayName}, nil
+}
+
+// Entitled user follow channel
+func (t *Client) Entitled(token string, user *core.Identity) (bool, error) {
+	return t.UserFollows(token, user.ID)
+}
+
+// GetEntitled return followers among twitchIDs and channel followers count
+func (t *Client) GetEntitled(twitchIDs []string) (map[string]string, int, error) {
+	return t.GetLinkedFollowers(twitchIDs)
+}
+
+// GetStatuses return subscriber tier, VIP and moderator statuses of users among twitchIDs,
+// only statuses enabled by broadcaster token capabilities, and founder status of founders set by SetFounders
+func (t *Client) GetStatuses(twitchIDs []string) (map[string][]core.Status, error) {
+	rs := make(map[string][]core.Status)
+
+	for _, id := range twitchIDs {
+		if t.founders[id] {
+			rs[id] = append(rs[id], core.StatusFounder)
+		}
+	}
+
+	endpoints := []struct {
+		capability string
+		path       string
+		status     func(u channelUser) core.Status
+	}{
+		{CapModerators, "/moderation/moderators", func(_ channelUser) core.Status { return core.StatusModerator }},
+		{CapVIPs, "/channels/vips", func(_ channelUser) core.Status { return core.StatusVIP }},
+		{CapSubscriptions, "/subscriptions", func(u channelUser) core.Status { return subTiers[u.Tier] }},
+	}
+
+	for _, e := range endpoints {
+		if !t.HasCapability(e.capability) {
+			continue
+		}
+
+		for start := 0; start < len(twitchIDs); start += usersPerRequest {
+			end := start + usersPerRequest
+			if end > len(twitchIDs) {
+				end = len(twitchIDs)
+			}
+
+			users, err := t.channelUsers(e.path, twitchIDs[start:end])
+			if err != nil {
+				return nil, err
+			}
+
+			for _, u := range users {
+				if status := e.status(u); status != "" {
+					rs[u.UserID] = append(rs[u.UserID], status)
+				}
+			}
+		}
+	}
+
+	return rs, nil
+}
+
+// channelUsers all pages of channel users endpoint (moderators, VIPs, subscribers) among twitchIDs,
+// helix return 20 users per page by default
+func (t *Client) channelUsers(path string, twitchIDs []string) ([]channelUser, error) {
+	query := url.Values{
+		"broadcaster_id": {t.broadcasterID},
+		"user_id":        twitchIDs,
+		"first":          {fmt.Sprint(usersPerRequest)},
+	}
+
+	var rs []channelUser
+	for {
+		resp := &channelUsersResponse{}
+		err := t.moderator.do(func(token string) error {
+			return t.helixGet(path, query, token, resp)
+		})
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, resp.Data...)
+
+		if resp.Pagination.Cursor == "" {
+			return rs, nil
+		}
+		query.Set("after", resp.Pagination.Cursor)
+	}
+}
+
+// UserFollows check user follow channel, by user own token with user:read:follows scope
+func (t *Client) UserFollows(token, twitchID string) (bool, error) {
+	rs := &followedResponse{}
+
+	err := t.helixGet("/channels/followed", url.Values{
+		"user_id":        {twitchID},
+		"broadcaster_id": {t.broadcasterID},
+	}, token, rs)
+	if err != nil {
+		return false, err
+	}
+
+	return len(rs.Data) > 0, nil
+}
+
+// Get channel followers
+// return map[twitchID]twitchName
+func (t *Client) GetFollowers() (map[string]string, error) {
+	rs := make(map[string]string, 0)
+
+	if _, err := t.pageFollowers("", rs); err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+// followersPage one page of channel followers after cursor, empty cursor for first page
+func (t *Client) followersPage(cursor string) (*followersResponse, error) {
+	query := url.Values{
+		"broadcaster_id": {t.broadcasterID},
+		"first":          {fmt.Sprint(followersPageSize)},
+	}
+	if cursor != "" {
+		query.Set("after", cursor)
+	}
+
+	resp := &followersResponse{}
+	err := t.moderator.do(func(token string) error {
+		return t.helixGet("/channels/followers", query, token, resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// pageFollowers add followers from cursor to last page into rs, return channel followers count
+func (t *Client) pageFollowers(cursor string, rs map[string]string) (int, error) {
+	for {
+		resp, err := t.followersPage(cursor)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, flw := range resp.Data {
+			rs[flw.UserID] = flw.UserLogin
+		}
+
+		if resp.Pagination.Cursor == "" {
+			return resp.Total, nil
+		}
+		cursor = resp.Pagination.Cursor
+	}
+}
+
+// IsFollower check user follow channel, return login of follower, positive result cached for followCacheTTL
+func (t *Client) IsFollower(twitchID string) (string, bool, error) {
+	if login, found := t.cachedFollower(twitchID); found {
+		return login, true, nil
+	}
+
+	resp := &followersResponse{}
+	err := t.moderator.do(func(token string) error {
+		return t.helixGet("/channels/followers", url.Values{
+			"broadcaster_id": {t.broadcasterID},
+			"user_id":        {twitchID},
+		}, token, resp)
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	if len(resp.Data) == 0 {
+		return "", false, nil
+	}
+
+	login := resp.Data[0].UserLogin
+	t.follows.SetDefault(twitchID, login)
+
+	return login, true, nil
+}
+
+// cachedFollower login of follower checked in last followCacheTTL
+func (t *Client) cachedFollower(twitchID string) (string, bool) {
+	v, found := t.follows.Get(twitchID)
+	if !found {
+		return "", false
+	}
+	login, ok := v.(string)
+	return login, ok
+}
+
+// GetLinkedFollowers return followers among twitchIDs and channel followers count.
+// First followers page give count and check newest followers at once, rest of users
+// checked by cheaper strategy: one request per not cached user or remaining followers pages
+func (t *Client) GetLinkedFollowers(twitchIDs []string) (map[string]string, int, error) {
+	first, err := t.followersPage("")
+	if err != nil {
+		return nil, 0, err
+	}
+	total := first.Total
+
+	followers := make(map[string]string, len(first.Data))
+	for _, flw := range first.Data {
+		followers[flw.UserID] = flw.UserLogin
+	}
+
+	rs := make(map[string]string, len(twitchIDs))
+
+	var unchecked []string
+	for _, id := range twitchIDs {
+		if login, found := followers[id]; found {
+			t.follows.SetDefault(id, login)
+			rs[id] = login
+			continue
+		}
+		if login, found := t.cachedFollower(id); found {
+			rs[id] = login
+			continue
+		}
+		unchecked = append(unchecked, id)
+	}
+
+	// all followers fit in first page, unchecked users do not follow
+	if len(unchecked) == 0 || first.Pagination.Cursor == "" {
+		return rs, total, nil
+	}
+
+	pages := (total - len(first.Data) + followersPageSize - 1) / followersPageSize
+
+	if len(unchecked) <= pages {
+		log.Printf("Check %d users follow one by one, instead of %d followers pages\n", len(unchecked), pages)
+
+		for _, id := range unchecked {
+			login, follower, err := t.IsFollower(id)
+			if err != nil {
+				return nil, 0, err
+			}
+			if follower {
+				rs[id] = login
+			}
+		}
+
+		return rs, total, nil
+	}
+
+	log.Printf("Download %d followers pages, instead of check %d users\n", pages, len(unchecked))
+
+	total, err = t.pageFollowers(first.Pagination.Cursor, followers)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, id := range unchecked {
+		if login, found := followers[id]; found {
+			t.follows.SetDefault(id, login)
+			rs[id] = login
+		}
+	}
+
+	return rs, total, nil
+}
@@ -0,0 +1,392 @@
+package twitch
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leporel/ttg/core"
+	"github.com/leporel/ttg/internal/fake"
+	"github.com/leporel/ttg/storage"
+	"github.com/patrickmn/go-cache"
+)
+
+func newFakeTwitchApp(t *testing.T, f *fake.Helix) *Client {
+	srv := httptest.NewServer(f.Handler())
+	t.Cleanup(srv.Close)
+
+	app := &Client{
+		clientID:      "client",
+		apiCode:       "secret",
+		broadcasterID: f.BroadcasterID,
+		apiBaseURL:    srv.URL,
+		authBaseURL:   srv.URL + "/oauth2",
+		httpClient:    srv.Client(),
+		follows:       cache.New(followCacheTTL, 10*time.Minute),
+	}
+	app.initTokens()
+	app.SetModeratorToken(&storage.Token{
+		Name:         storage.TokenBroadcaster,
+		AccessToken:  f.Moderator,
+		RefreshToken: "mod-refresh",
+		ExpiresAt:    time.Now().Add(time.Hour),
+	})
+
+	return app
+}
+
+func TestTwitchFollowers(t *testing.T) {
+	f := &fake.Helix{
+		BroadcasterID: "100",
+		Moderator:     "mod-token",
+		Followers:     fake.NewFollowers(250),
+		Users:         map[string]string{"user-token": "7", "stranger-token": "9999"},
+	}
+	app := newFakeTwitchApp(t, f)
+
+	followers, err := app.GetFollowers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(followers) != 250 {
+		t.Fatalf("expected 250 followers, got %d", len(followers))
+	}
+	if n := f.ResetCount(); n != 3 {
+		t.Fatalf("expected 3 pages, got %d requests", n)
+	}
+
+	follows, err := app.UserFollows("user-token", "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !follows {
+		t.Fatal("user 7 must follow channel")
+	}
+
+	follows, err = app.UserFollows("stranger-token", "9999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if follows {
+		t.Fatal("user 9999 must not follow channel")
+	}
+}
+
+func TestTwitchLinkedFollowers(t *testing.T) {
+	f := &fake.Helix{
+		BroadcasterID: "100",
+		Moderator:     "mod-token",
+		Followers:     fake.NewFollowers(1000),
+	}
+	app := newFakeTwitchApp(t, f)
+
+	// user 5 found on first page, user 5000 checked alone instead of 9 pages
+	followers, total, err := app.GetLinkedFollowers([]string{"5", "5000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1000 || len(followers) != 1 || followers["5"] != "user5" {
+		t.Fatalf("wrong linked followers %v, total %d", followers, total)
+	}
+	if n := f.ResetCount(); n != 2 {
+		t.Fatalf("expected first page and 1 user request, got %d", n)
+	}
+
+	// users beyond first page checked one by one, positive result cached
+	followers, _, err = app.GetLinkedFollowers([]string{"500", "5000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(followers) != 1 || followers["500"] != "user500" {
+		t.Fatalf("wrong linked followers %v", followers)
+	}
+	if n := f.ResetCount(); n != 3 {
+		t.Fatalf("expected first page and 2 user requests, got %d", n)
+	}
+	_, _, err = app.GetLinkedFollowers([]string{"500"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := f.ResetCount(); n != 1 {
+		t.Fatalf("expected only first page request, got %d", n)
+	}
+
+	// all followers on first page, no other requests
+	small := &fake.Helix{BroadcasterID: "100", Moderator: "mod-token", Followers: fake.NewFollowers(50)}
+	followers, total, err = newFakeTwitchApp(t, small).GetLinkedFollowers([]string{"7", "500", "600"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 50 || len(followers) != 1 {
+		t.Fatalf("wrong linked followers %v, total %d", followers, total)
+	}
+	if n := small.ResetCount(); n != 1 {
+		t.Fatalf("expected only first page request, got %d", n)
+	}
+
+	// 20 unchecked users and 9 pages left, full list downloaded
+	ids := make([]string, 0, 20)
+	for i := 980; i < 1000; i++ {
+		ids = append(ids, strconv.Itoa(i))
+	}
+	followers, _, err = app.GetLinkedFollowers(ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(followers) != 20 {
+		t.Fatalf("expected 20 followers, got %d", len(followers))
+	}
+	if n := f.ResetCount(); n != 10 {
+		t.Fatalf("expected 10 pages requests, got %d", n)
+	}
+}
+
+func TestTwitchLinkedFollowersFlush(t *testing.T) {
+	f := &fake.Helix{BroadcasterID: "100", Moderator: "mod-token", Followers: fake.NewFollowers(1000)}
+	app := newFakeTwitchApp(t, f)
+
+	// cache flushed by /connect callback while sweep checks users one by one
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			app.follows.Flush()
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		followers, _, err := app.GetLinkedFollowers([]string{"500", "600", "5000"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(followers) != 2 || followers["600"] != "user600" {
+			t.Fatalf("wrong linked followers %v", followers)
+		}
+	}
+	wg.Wait()
+}
+
+func TestTwitchNotConnected(t *testing.T) {
+	f := &fake.Helix{BroadcasterID: "100", Moderator: "mod-token"}
+	app := newFakeTwitchApp(t, f)
+
+	app.SetModeratorToken(&storage.Token{
+		AccessToken:  "revoked",
+		RefreshToken: "revoked",
+		ExpiresAt:    time.Now().Add(time.Hour),
+	})
+	_, err := app.GetFollowers()
+	if err == nil {
+		t.Fatal("expected error with revoked refresh token")
+	}
+
+	app.SetModeratorToken(nil)
+	_, err = app.GetFollowers()
+	if !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("expected not connected error, got %v", err)
+	}
+}
+
+func TestTwitchCapabilities(t *testing.T) {
+	f := &fake.Helix{BroadcasterID: "100", Moderator: "mod-token"}
+	app := newFakeTwitchApp(t, f)
+
+	app.SetModeratorToken(&storage.Token{
+		UserID: "100",
+		Scopes: []string{"moderator:read:followers", "channel:read:subscriptions"},
+	})
+
+	if !app.HasCapability(CapFollowers) || !app.HasCapability(CapSubscriptions) {
+		t.Fatal("broadcaster token must enable followers and subscriptions")
+	}
+	if app.HasCapability(CapVIPs) {
+		t.Fatal("vips must be disabled without scope")
+	}
+
+	app.SetModeratorToken(&storage.Token{
+		UserID: "200",
+		Scopes: []string{"moderator:read:followers", "channel:read:subscriptions"},
+	})
+
+	if !app.HasCapability(CapFollowers) {
+		t.Fatal("moderator token must enable followers")
+	}
+	if app.HasCapability(CapSubscriptions) {
+		t.Fatal("moderator token must not enable subscriptions")
+	}
+
+	t.Log(app.CapabilitiesReport())
+}
+
+func TestTwitchStatuses(t *testing.T) {
+	f := &fake.Helix{
+		BroadcasterID: "100",
+		Moderator:     "mod-token",
+		Subs:          map[string]string{"1": "1000", "2": "3000", "150": "2000"},
+		VIPs:          []string{"2"},
+		Mods:          []string{"3"},
+	}
+	// more moderators in first request than helix return on page by default
+	for i := 11; i <= 40; i++ {
+		f.Mods = append(f.Mods, strconv.Itoa(i))
+	}
+	app := newFakeTwitchApp(t, f)
+	app.SetModeratorToken(&storage.Token{
+		UserID:      "100",
+		AccessToken: f.Moderator,
+		Scopes:      []string{"channel:read:subscriptions", "moderation:read"},
+		ExpiresAt:   time.Now().Add(time.Hour),
+	})
+	// founders listed by broadcaster, one of them not among users
+	app.SetFounders([]string{"1", "4", "500"})
+
+	ids := make([]string, 0, 150)
+	for i := 1; i <= 150; i++ {
+		ids = append(ids, strconv.Itoa(i))
+	}
+
+	statuses, err := app.GetStatuses(ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// vips scope missing, user 2 is only tier 3 subscriber
+	want := map[string][]core.Status{
+		"1":   {core.StatusFounder, core.StatusSubscriber1},
+		"2":   {core.StatusSubscriber3},
+		"3":   {core.StatusModerator},
+		"4":   {core.StatusFounder},
+		"150": {core.StatusSubscriber2},
+	}
+	for i := 11; i <= 40; i++ {
+		want[strconv.Itoa(i)] = []core.Status{core.StatusModerator}
+	}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Fatalf("got %v, want %v", statuses, want)
+	}
+	// 100 users per request and per page, 31 moderators of first request on one page
+	if n := f.ResetCount(); n != 4 {
+		t.Fatalf("expected 2 requests per enabled endpoint, got %d", n)
+	}
+}
+
+func TestTwitchTokenRetryOn401(t *testing.T) {
+	f := &fake.Helix{BroadcasterID: "100", Moderator: "mod-token", Followers: fake.NewFollowers(5)}
+	app := newFakeTwitchApp(t, f)
+
+	// token revoked by twitch before expiry
+	f.SetModerator("rotated")
+
+	followers, err := app.GetFollowers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(followers) != 5 {
+		t.Fatalf("expected 5 followers, got %d", len(followers))
+	}
+	if f.TokensIssued() != 1 {
+		t.Fatalf("expected one refresh, got %d", f.TokensIssued())
+	}
+}
+
+func TestTwitchTokenRefreshAhead(t *testing.T) {
+	f := &fake.Helix{BroadcasterID: "100", Moderator: "mod-token"}
+	app := newFakeTwitchApp(t, f)
+
+	app.app.set(&storage.Token{AccessToken: "old", ExpiresAt: time.Now().Add(tokenRefreshAhead / 2)})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := app.app.get(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if f.TokensIssued() != 1 {
+		t.Fatalf("expected one app token request, got %d", f.TokensIssued())
+	}
+}
+
+func TestTwitchTokenValidate(t *testing.T) {
+	f := &fake.Helix{BroadcasterID: "100", Moderator: "mod-token"}
+	app := newFakeTwitchApp(t, f)
+
+	if _, err := app.app.get(); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.ValidateTokens(); err != nil {
+		t.Fatal(err)
+	}
+	if f.TokensIssued() != 1 {
+		t.Fatalf("valid tokens must not be refreshed, issued %d", f.TokensIssued())
+	}
+
+	// app token revoked
+	f.RevokeAppToken()
+
+	if err := app.ValidateTokens(); err != nil {
+		t.Fatal(err)
+	}
+	if f.TokensIssued() != 2 {
+		t.Fatalf("invalid app token must be refreshed, issued %d", f.TokensIssued())
+	}
+}
+
+func TestTwitchConcurrentCallbacks(t *testing.T) {
+	f := &fake.Helix{
+		BroadcasterID: "100",
+		Moderator:     "mod-token",
+		Followers:     fake.NewFollowers(50),
+		Users:         map[string]string{},
+		Codes:         map[string]string{},
+	}
+	for i := 1; i <= 100; i++ {
+		f.Codes[fmt.Sprintf("code-%d", i)] = strconv.Itoa(i)
+	}
+	app := newFakeTwitchApp(t, f)
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			token, err := app.GetUserToken(fmt.Sprintf("code-%d", i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			user, err := app.GetUser(token)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if user.ID != strconv.Itoa(i) {
+				t.Errorf("callback %d got user %s", i, user.ID)
+				return
+			}
+
+			follows, err := app.UserFollows(token, user.ID)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if follows != (i <= 50) {
+				t.Errorf("user %d follows %v", i, follows)
+			}
+		}(i)
+	}
+	wg.Wait()
+}